@@ -0,0 +1,177 @@
+package main
+
+import (
+	"maps"
+	"slices"
+)
+
+// CategoryGroup is a bit set, so a game's groups can be matched against a filter with a single AND
+type CategoryGroup uint8
+
+const (
+	// multiplayer without specifying how, like "Multi-player" or "Co-op"
+	CategoryGroupMultiplayer CategoryGroup = 1 << iota
+	CategoryGroupOnlineCoop
+	CategoryGroupOnlinePvP
+	CategoryGroupLocal
+	CategoryGroupMMO
+	CategoryGroupCrossPlatform
+	// the members that don't own it can join the one that does
+	CategoryGroupRemotePlay
+)
+
+// CategoryGroupsOnline are listed when no filter is set, the local and Remote Play Together
+// games are only listed when asked for
+const CategoryGroupsOnline = CategoryGroupMultiplayer | CategoryGroupOnlineCoop | CategoryGroupOnlinePvP | CategoryGroupMMO | CategoryGroupCrossPlatform
+
+type Category struct {
+	ID    int
+	Name  string
+	Group CategoryGroup
+}
+
+const categoryRemotePlayTogether = 44
+
+// Default names are overridden by the descriptions returned by the steam API.
+// Categories not listed here are not multiplayer.
+var categoryCatalog = []Category{
+	{1, "Multi-player", CategoryGroupMultiplayer},
+	{9, "Co-op", CategoryGroupMultiplayer},
+	{20, "MMO", CategoryGroupMMO},
+	{24, "Shared/Split Screen", CategoryGroupLocal},
+	{27, "Cross-Platform Multiplayer", CategoryGroupCrossPlatform},
+	{36, "Online PvP", CategoryGroupOnlinePvP},
+	{37, "Shared/Split Screen PvP", CategoryGroupLocal},
+	{38, "Online Co-op", CategoryGroupOnlineCoop},
+	{39, "Shared/Split Screen Co-op", CategoryGroupLocal},
+	{categoryRemotePlayTogether, "Remote Play Together", CategoryGroupRemotePlay},
+	{47, "LAN PvP", CategoryGroupLocal},
+	{48, "LAN Co-op", CategoryGroupLocal},
+	{49, "PvP", CategoryGroupMultiplayer},
+}
+
+func getCategory(id int, cache *CacheGroup) Category {
+	category := Category{ID: id}
+
+	index := slices.IndexFunc(categoryCatalog, func(c Category) bool {
+		return c.ID == id
+	})
+	if index != -1 {
+		category = categoryCatalog[index]
+	}
+
+	if name, ok := cache.categoryNames.Get(id); ok {
+		category.Name = name
+	}
+
+	return category
+}
+
+// category id -> group, only the multiplayer ones
+var categoryGroupByID = func() map[int]CategoryGroup {
+	groups := make(map[int]CategoryGroup, len(categoryCatalog))
+	for _, category := range categoryCatalog {
+		groups[category.ID] = category.Group
+	}
+	return groups
+}()
+
+func getCategoriesGroups(categories []int) CategoryGroup {
+	var groups CategoryGroup
+	for _, id := range categories {
+		groups |= categoryGroupByID[id]
+	}
+	return groups
+}
+
+// setGameCategories caches the categories of a game, and its groups in the index
+func setGameCategories(appID int, categories []int, cache *CacheGroup) {
+	cache.gameCategories.Set(appID, categories)
+	cache.categoryGroups.Set(appID, getCategoriesGroups(categories))
+}
+
+// loadCategoryGroupIndex fills the index with the groups of every game in the store, so the
+// games can be filtered without their categories
+func loadCategoryGroupIndex(store Store, cache *CacheGroup) (count int, err error) {
+	categoriesPerGame, err := store.GameCategoriesIn(slices.Collect(maps.Keys(categoryGroupByID)))
+	if err != nil {
+		return 0, err
+	}
+	for appID, categories := range categoriesPerGame {
+		cache.categoryGroups.Set(appID, getCategoriesGroups(categories))
+	}
+	return len(categoriesPerGame), nil
+}
+
+// getMultiplayerBadges returns the multiplayer categories worth showing, the generic ones
+// are only used when there is nothing more specific.
+func getMultiplayerBadges(categories []int, cache *CacheGroup) []Category {
+	var specific, generic []Category
+
+	for _, id := range categories {
+		category := getCategory(id, cache)
+
+		switch {
+		case category.Group == 0 || len(category.Name) == 0:
+			continue
+		case category.Group == CategoryGroupMultiplayer:
+			generic = append(generic, category)
+		default:
+			specific = append(specific, category)
+		}
+	}
+
+	if len(specific) > 0 {
+		return specific
+	}
+	return generic
+}
+
+// The groups the user can filter by, "name" is used in the query params and preferences
+var categoryGroupFilters = []struct {
+	Group CategoryGroup
+	Name  string
+	Label string
+}{
+	{CategoryGroupOnlineCoop, "online-coop", "Online Co-op"},
+	{CategoryGroupOnlinePvP, "online-pvp", "Online PvP"},
+	{CategoryGroupLocal, "local", "Local/Split Screen"},
+	{CategoryGroupMMO, "mmo", "MMO"},
+	{CategoryGroupCrossPlatform, "cross-platform", "Cross-Platform"},
+}
+
+// parseCategoryGroupFilter ignores empty names, so a form can always send the param
+// even with nothing selected.
+func parseCategoryGroupFilter(names []string) (CategoryGroup, bool) {
+	var groups CategoryGroup
+
+	for _, name := range names {
+		if len(name) == 0 {
+			continue
+		}
+
+		found := false
+		for _, f := range categoryGroupFilters {
+			if f.Name == name {
+				groups |= f.Group
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, false
+		}
+	}
+
+	return groups, true
+}
+
+func formatCategoryGroupFilter(groups CategoryGroup) []string {
+	names := make([]string, 0, len(categoryGroupFilters))
+	for _, f := range categoryGroupFilters {
+		if groups&f.Group != 0 {
+			names = append(names, f.Name)
+		}
+	}
+	return names
+}
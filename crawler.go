@@ -0,0 +1,354 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// CrawlPriority orders the crawl queue, lower goes first
+type CrawlPriority uint8
+
+const (
+	// a user is waiting for the page the app is in
+	CrawlPriorityPage CrawlPriority = iota
+	// the app is in the next page of a user
+	CrawlPriorityPrefetch
+	// nobody is waiting for it
+	CrawlPriorityRecrawl
+)
+
+// How long a worker waits for the steam API before moving to the next app
+const crawlFetchTimeout = 20 * time.Second
+
+// The fetched categories are saved to the store in batches of this size, or after
+// crawlSaveInterval if there are not enough.
+const (
+	crawlSaveBatchSize = 50
+	crawlSaveInterval  = 2 * time.Second
+)
+
+// When idle, the due apps of the crawl queue in the store are resumed in batches
+const (
+	crawlResumeBatchSize = 50
+	crawlResumeInterval  = 30 * time.Second
+)
+
+// crawlRetryDelay doubles with each failed attempt, up to about a day
+func crawlRetryDelay(attempts int) time.Duration {
+	return min(time.Minute<<min(attempts, 10), 24*time.Hour)
+}
+
+type gameCategoriesResult struct {
+	AppID      int
+	Categories []int
+	Err        error
+}
+
+type crawlJob struct {
+	AppID    int
+	Priority CrawlPriority
+	// order of arrival, so jobs with the same priority are fifo
+	seq uint64
+	// index in the queue, -1 once a worker took it
+	index       int
+	subscribers []chan<- gameCategoriesResult
+	// failed attempts before this one
+	Attempts int
+}
+
+type crawlOutcome struct {
+	Result   gameCategoriesResult
+	Attempts int
+}
+
+type crawlQueue []*crawlJob
+
+func (q crawlQueue) Len() int {
+	return len(q)
+}
+
+func (q crawlQueue) Less(i, j int) bool {
+	if q[i].Priority != q[j].Priority {
+		return q[i].Priority < q[j].Priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q crawlQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *crawlQueue) Push(x any) {
+	job := x.(*crawlJob)
+	job.index = len(*q)
+	*q = append(*q, job)
+}
+
+func (q *crawlQueue) Pop() any {
+	old := *q
+	job := old[len(old)-1]
+	old[len(old)-1] = nil
+	job.index = -1
+	*q = old[:len(old)-1]
+	return job
+}
+
+// CategoryCrawler fetches game categories from the steam API for the whole process,
+// so concurrent requests share the same workers and an app is never fetched twice at
+// the same time. The results are saved to the store by a single writer.
+//
+// The queue is also kept in the store until each app is fetched, with the failed
+// attempts, so it's resumed after a restart and the failures are retried later.
+type CategoryCrawler struct {
+	store Store
+	cache *CacheGroup
+
+	queue crawlQueue
+	// appid -> queued or in progress job
+	jobs    map[int]*crawlJob
+	nextSeq uint64
+	mu      sync.Mutex
+	cond    *sync.Cond
+
+	// apps queued by subscribers, to save them in the store
+	queued chan []int
+	done   chan crawlOutcome
+}
+
+func NewCategoryCrawler(store Store, cache *CacheGroup) *CategoryCrawler {
+	c := &CategoryCrawler{
+		store:  store,
+		cache:  cache,
+		jobs:   make(map[int]*crawlJob),
+		queued: make(chan []int, crawlSaveBatchSize),
+		done:   make(chan crawlOutcome, crawlSaveBatchSize),
+	}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Start runs the workers, the writer and the resumer in the background
+func (c *CategoryCrawler) Start(workers int) {
+	for range workers {
+		go c.work()
+	}
+	go c.write()
+	go c.resume()
+}
+
+// Subscribe queues the apps that are not queued yet, raises the priority of the ones that
+// are, and returns a channel that receives the result of each app once. The channel has
+// room for all of them, so it doesn't need to be drained.
+func (c *CategoryCrawler) Subscribe(appIDs []int, priority CrawlPriority) <-chan gameCategoriesResult {
+	results := make(chan gameCategoriesResult, len(appIDs))
+	var queued []int
+
+	c.mu.Lock()
+	for _, appID := range appIDs {
+		job, ok := c.jobs[appID]
+		if !ok {
+			job = c.push(appID, priority, 0)
+			queued = append(queued, appID)
+		} else if priority < job.Priority && job.index != -1 {
+			job.Priority = priority
+			heap.Fix(&c.queue, job.index)
+		}
+		job.subscribers = append(job.subscribers, results)
+	}
+
+	// sent before a worker can take them, so the writer queues them in the store before
+	// it deletes them
+	if len(queued) > 0 {
+		c.queued <- queued
+	}
+	c.mu.Unlock()
+
+	return results
+}
+
+// Backlog is the number of apps waiting for a worker
+func (c *CategoryCrawler) Backlog() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.queue)
+}
+
+// push must be called with the crawler locked
+func (c *CategoryCrawler) push(appID int, priority CrawlPriority, attempts int) *crawlJob {
+	job := &crawlJob{
+		AppID:    appID,
+		Priority: priority,
+		seq:      c.nextSeq,
+		Attempts: attempts,
+	}
+	c.nextSeq++
+	c.jobs[appID] = job
+	heap.Push(&c.queue, job)
+	c.cond.Signal()
+	return job
+}
+
+// resume queues the due apps of the store's crawl queue, when nothing else is queued
+func (c *CategoryCrawler) resume() {
+	ticker := time.NewTicker(crawlResumeInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		c.mu.Lock()
+		idle := len(c.jobs) == 0
+		c.mu.Unlock()
+		if !idle {
+			continue
+		}
+
+		entries, err := c.store.DueCrawls(time.Now(), crawlResumeBatchSize)
+		if err != nil {
+			slog.Error("get due crawls", "err", err)
+			continue
+		}
+		if len(entries) == 0 {
+			continue
+		}
+		slog.Debug("CategoryCrawler: resuming crawls", "count", len(entries))
+
+		c.mu.Lock()
+		for _, entry := range entries {
+			if _, ok := c.jobs[entry.AppID]; !ok {
+				c.push(entry.AppID, CrawlPriorityRecrawl, entry.Attempts)
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+func (c *CategoryCrawler) work() {
+	for {
+		c.mu.Lock()
+		for len(c.queue) == 0 {
+			c.cond.Wait()
+		}
+		job := heap.Pop(&c.queue).(*crawlJob)
+		c.mu.Unlock()
+
+		// waiting for the limit is not part of the timeout
+		storeAPILimiter.Wait()
+
+		ctx, cancel := context.WithTimeout(context.Background(), crawlFetchTimeout)
+		categories, err := fetchSteamGameCategories(ctx, job.AppID, c.cache)
+		cancel()
+
+		result := gameCategoriesResult{job.AppID, categories, err}
+
+		c.mu.Lock()
+		delete(c.jobs, job.AppID)
+		subscribers := job.subscribers
+		c.mu.Unlock()
+
+		for _, results := range subscribers {
+			results <- result
+		}
+
+		c.done <- crawlOutcome{result, job.Attempts}
+	}
+}
+
+// write saves the fetched categories, and the names of the categories they have, and keeps
+// the crawl queue of the store up to date
+func (c *CategoryCrawler) write() {
+	ticker := time.NewTicker(crawlSaveInterval)
+	defer ticker.Stop()
+
+	pending := make(map[int][]int, crawlSaveBatchSize)
+
+	queue := func(appIDs []int) {
+		err := c.store.QueueCrawls(appIDs)
+		if err != nil {
+			slog.Error("queue crawls", "count", len(appIDs), "err", err)
+		}
+	}
+
+	for {
+		select {
+		case appIDs := <-c.queued:
+			queue(appIDs)
+			continue
+		case outcome := <-c.done:
+			// the app of the outcome was sent to queued before, it may still be waiting
+			for len(c.queued) > 0 {
+				queue(<-c.queued)
+			}
+
+			result := outcome.Result
+			if result.Err != nil {
+				// a subscriber queues an app that failed before with no attempts, they
+				// are kept in the store
+				attempts := outcome.Attempts
+				entry, ok, err := c.store.Crawl(result.AppID)
+				if err != nil {
+					slog.Error("get crawl", "appid", result.AppID, "err", err)
+				} else if ok {
+					attempts = max(attempts, entry.Attempts)
+				}
+				attempts++
+
+				err = c.store.SaveCrawlAttempt(CrawlEntry{
+					AppID:         result.AppID,
+					Attempts:      attempts,
+					NextAttemptAt: time.Now().Add(crawlRetryDelay(attempts)),
+					LastError:     result.Err.Error(),
+				})
+				if err != nil {
+					slog.Error("save crawl attempt", "appid", result.AppID, "err", err)
+				}
+				continue
+			}
+
+			pending[result.AppID] = result.Categories
+			if len(pending) < crawlSaveBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(pending) == 0 {
+				continue
+			}
+		}
+
+		err := c.save(pending)
+		if err != nil {
+			slog.Error("save crawled game categories", "count", len(pending), "err", err)
+		} else {
+			slog.Debug("CategoryCrawler: saved game categories", "count", len(pending))
+		}
+		pending = make(map[int][]int, crawlSaveBatchSize)
+	}
+}
+
+func (c *CategoryCrawler) save(categoriesPerGame map[int][]int) error {
+	err := c.store.SaveGameCategories(categoriesPerGame)
+	if err != nil {
+		return err
+	}
+
+	err = c.store.DeleteCrawls(slices.Collect(maps.Keys(categoriesPerGame)))
+	if err != nil {
+		return err
+	}
+
+	categoryNames := make(map[int]string)
+	for _, categories := range categoriesPerGame {
+		for _, id := range categories {
+			if name, ok := c.cache.categoryNames.Get(id); ok && len(name) > 0 {
+				categoryNames[id] = name
+			}
+		}
+	}
+	return c.store.SaveCategoryNames(categoryNames)
+}
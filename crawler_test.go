@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeStoreAPI answers appdetails with categories, the apps whose appid is a multiple of 3
+// are single-player only. It counts the requests for each app.
+type fakeStoreAPI struct {
+	requests map[int]int
+	mu       sync.Mutex
+}
+
+func (f *fakeStoreAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	appID, err := strconv.Atoi(r.URL.Query().Get("appids"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	f.requests[appID]++
+	f.mu.Unlock()
+
+	categories := []map[string]any{{"id": 2, "description": "Single-player"}}
+	if appID%3 != 0 {
+		categories = append(categories, map[string]any{"id": 1, "description": "Multi-player"})
+	}
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		strconv.Itoa(appID): map[string]any{
+			"success": true,
+			"data":    map[string]any{"categories": categories},
+		},
+	})
+}
+
+func (f *fakeStoreAPI) Requests() map[int]int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	requests := make(map[int]int, len(f.requests))
+	for appID, count := range f.requests {
+		requests[appID] = count
+	}
+	return requests
+}
+
+// redirectTransport sends every request to the test server
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (t redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = t.target.Scheme
+	req.URL.Host = t.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func startFakeStoreAPI(t *testing.T) *fakeStoreAPI {
+	t.Helper()
+
+	api := &fakeStoreAPI{requests: make(map[int]int)}
+	useTestServer(t, api)
+	useFastStoreAPILimiter(t)
+	return api
+}
+
+// useFastStoreAPILimiter lifts the limit of the store API until the test ends
+func useFastStoreAPILimiter(t *testing.T) {
+	limiter := storeAPILimiter
+	storeAPILimiter = newRateLimiter(time.Microsecond, 1000)
+	t.Cleanup(func() {
+		storeAPILimiter = limiter
+	})
+}
+
+// useTestServer sends the requests of http.DefaultClient to handler until the test ends
+func useTestServer(t *testing.T, handler http.Handler) {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	target, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transport := http.DefaultClient.Transport
+	http.DefaultClient.Transport = redirectTransport{target}
+	t.Cleanup(func() {
+		http.DefaultClient.Transport = transport
+	})
+}
+
+func testGroupGames(count int) []GroupGame {
+	games := make([]GroupGame, count)
+	for i := range games {
+		games[i] = GroupGame{
+			AppID:       i + 1,
+			Name:        fmt.Sprintf("Game %d", i+1),
+			PriceStatus: PricePaid,
+			Members:     []GroupMember{{SteamID: "1", Owned: true}},
+		}
+	}
+	return games
+}
+
+func TestGamesListConcurrentStreams(t *testing.T) {
+	api := startFakeStoreAPI(t)
+
+	store := NewMemoryStore()
+	cache := newCacheGroup()
+	crawler := NewCategoryCrawler(store, cache)
+	crawler.Start(10)
+
+	const gamesCount = 300
+	const pageSize = 20
+	sortedGames := testGroupGames(gamesCount)
+
+	var appIDs, want []int
+	for _, game := range sortedGames {
+		appIDs = append(appIDs, game.AppID)
+		if game.AppID%3 != 0 {
+			want = append(want, game.AppID)
+		}
+	}
+
+	// lists for different filters share the crawler, each one is paged by a few readers
+	filters := []GamesListFilter{
+		{ShowFree: true, ShowPaid: true},
+		{CategoryGroups: CategoryGroupMultiplayer, ShowFree: true, ShowPaid: true},
+	}
+
+	var wg, prefetches sync.WaitGroup
+	errs := make(chan error, 64)
+
+	for _, filter := range filters {
+		list := newGamesList(sortedGames, filter)
+
+		for range 3 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				var got []int
+				cursor := ""
+				for {
+					next, err := list.Stream(cursor, pageSize, store, cache, crawler, func(item GamesListItem) {
+						got = append(got, item.Game.AppID)
+					})
+					if err != nil {
+						errs <- fmt.Errorf("stream (cursor=%q): %v", cursor, err)
+						return
+					}
+					prefetches.Add(1)
+					go func() {
+						defer prefetches.Done()
+						list.Prefetch(pageSize, store, cache, crawler)
+					}()
+
+					if len(next) == 0 {
+						break
+					}
+					cursor = next
+				}
+
+				if !slices.Equal(got, want) {
+					errs <- fmt.Errorf("listed %v, want %v", got, want)
+				}
+			}()
+		}
+	}
+
+	wg.Wait()
+	prefetches.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	// concurrent subscribers of the same app share a single fetch
+	for appID, count := range api.Requests() {
+		if count != 1 {
+			t.Errorf("appid %d fetched %d times", appID, count)
+		}
+	}
+
+	// the writer saves them in the background
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		saved, err := store.GameCategories(appIDs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(saved) == gamesCount {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("saved %d games, want %d", len(saved), gamesCount)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestFetchGamesCategoriesConcurrentSubscribers(t *testing.T) {
+	api := startFakeStoreAPI(t)
+
+	store := NewMemoryStore()
+	cache := newCacheGroup()
+	crawler := NewCategoryCrawler(store, cache)
+	crawler.Start(4)
+
+	appIDs := make([]int, 100)
+	for i := range appIDs {
+		appIDs[i] = i + 1
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// overlapping halves, with every priority
+			ids := appIDs[i%2*25 : i%2*25+75]
+			got := make(map[int][]int, len(ids))
+			err := fetchGamesCategories(ids, CrawlPriority(i%3), func(appID int, categories []int) {
+				got[appID] = categories
+			}, store, cache, crawler)
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			for _, appID := range ids {
+				categories, ok := got[appID]
+				if !ok {
+					errs <- fmt.Errorf("appid %d: no categories", appID)
+					continue
+				}
+				if want := appID%3 != 0; slices.Contains(categories, 1) != want {
+					errs <- fmt.Errorf("appid %d: categories %v", appID, categories)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	requests := api.Requests()
+	if len(requests) != len(appIDs) {
+		t.Errorf("fetched %d apps, want %d", len(requests), len(appIDs))
+	}
+	for appID, count := range requests {
+		if count != 1 {
+			t.Errorf("appid %d fetched %d times", appID, count)
+		}
+	}
+}
+
+func TestCategoryCrawlerQueue(t *testing.T) {
+	// the app 7 always fails
+	api := &fakeStoreAPI{requests: make(map[int]int)}
+	useTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("appids") == "7" {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		api.ServeHTTP(w, r)
+	}))
+	useFastStoreAPILimiter(t)
+
+	store := NewMemoryStore()
+	cache := newCacheGroup()
+	crawler := NewCategoryCrawler(store, cache)
+	crawler.Start(4)
+
+	// it failed 3 times before a restart
+	err := store.SaveCrawlAttempt(CrawlEntry{AppID: 7, Attempts: 3, NextAttemptAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	appIDs := make([]int, 50)
+	for i := range appIDs {
+		appIDs[i] = i + 1
+	}
+	results := crawler.Subscribe(appIDs, CrawlPriorityPage)
+	for range appIDs {
+		<-results
+	}
+
+	// the fetched apps leave the queue, even if the writer got them before they were queued
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		due, err := store.DueCrawls(time.Now().Add(48*time.Hour), len(appIDs))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(due) == 1 && due[0].AppID == 7 && due[0].Attempts == 4 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("queued %+v, want only the app 7 with 4 attempts", due)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestRateLimiterAvailable(t *testing.T) {
+	limiter := newRateLimiter(time.Hour, 3)
+	if got := limiter.Available(); got != 3 {
+		t.Fatalf("available %d before any call, want 3", got)
+	}
+
+	limiter.Wait()
+	limiter.Wait()
+	if got := limiter.Available(); got != 1 {
+		t.Fatalf("available %d after 2 calls, want 1", got)
+	}
+
+	limiter.Wait()
+	if got := limiter.Available(); got != 0 {
+		t.Fatalf("available %d after the burst, want 0", got)
+	}
+}
+
+func TestFetchSteamGameCategoriesStalledBody(t *testing.T) {
+	unblock := make(chan struct{})
+	defer close(unblock)
+
+	useTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"10": {"success": true, "data": {"categories": [`))
+		http.NewResponseController(w).Flush()
+		<-unblock
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	// the timeout fires while decoding, which must be an error and not a panic
+	_, err := fetchSteamGameCategories(ctx, 10, newCacheGroup())
+	if err == nil {
+		t.Fatal("got no error")
+	}
+}
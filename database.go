@@ -2,15 +2,43 @@ package main
 
 import (
 	"database/sql"
-	"encoding/binary"
 	"errors"
 	"fmt"
-	"math"
+	"maps"
+	"slices"
+	"strings"
+	"time"
 
-	_ "github.com/tursodatabase/go-libsql"
+	"github.com/tursodatabase/go-libsql"
 )
 
-func NewDatabase(url, token string) (*sql.DB, error) {
+type libsqlStore struct {
+	db *sql.DB
+	// only set for embedded replicas
+	connector *libsql.Connector
+}
+
+// NewLibsqlStore opens the database at url. If replicaPath is set, it opens an embedded
+// replica of the remote database instead, reads are served from the local file and writes
+// go to the remote one, which is synced every syncInterval.
+func NewLibsqlStore(url, token, replicaPath string, syncInterval time.Duration) (*libsqlStore, error) {
+	if len(replicaPath) > 0 {
+		opts := []libsql.Option{libsql.WithSyncInterval(syncInterval)}
+		if len(token) > 0 {
+			opts = append(opts, libsql.WithAuthToken(token))
+		}
+
+		connector, err := libsql.NewEmbeddedReplicaConnector(replicaPath, url, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("open embedded replica: %v", err)
+		}
+
+		return &libsqlStore{
+			db:        sql.OpenDB(connector),
+			connector: connector,
+		}, nil
+	}
+
 	if len(token) > 0 {
 		url += "?authToken=" + token
 	}
@@ -19,85 +47,262 @@ func NewDatabase(url, token string) (*sql.DB, error) {
 	if err != nil {
 		return nil, err
 	}
-	return db, nil
+	return &libsqlStore{db: db}, nil
 }
 
-func decodeDBGameCategories(encoded []byte) []int {
-	assert(len(encoded) >= 2)
-
-	numberOfCategories := binary.LittleEndian.Uint16(encoded)
-	encoded = encoded[2:]
-
-	if len(encoded) == 0 {
-		return nil
+func (s *libsqlStore) Close() error {
+	err := s.db.Close()
+	if s.connector != nil {
+		_ = s.connector.Close()
 	}
+	return err
+}
 
-	categories := make([]int, numberOfCategories)
-
-	for i := range numberOfCategories {
-		categories[i] = int(binary.LittleEndian.Uint16(encoded))
-		encoded = encoded[2:]
-	}
+// Keeps queries under SQLite's bound parameters limit, and each chunk is a single round trip.
+const dbQueryChunkSize = 500
 
-	return categories
+func sqlPlaceholders(count int, group string) string {
+	return strings.TrimSuffix(strings.Repeat(group+",", count), ",")
 }
 
-func encodeDBGameCategories(categories []int) []byte {
-	assert(len(categories) < math.MaxUint16, len(categories))
+func (s *libsqlStore) GameCategories(appIDs []int) (map[int][]int, error) {
+	categoriesPerGame := make(map[int][]int, len(appIDs))
 
-	encoded := make([]byte, 2+len(categories)*2) // count + items
-	w := encoded
+	for chunk := range slices.Chunk(appIDs, dbQueryChunkSize) {
+		args := make([]any, len(chunk))
+		for i, appID := range chunk {
+			args[i] = appID
+		}
 
-	binary.LittleEndian.PutUint16(w, uint16(len(categories)))
-	w = w[2:]
+		rows, err := s.db.Query(`
+			SELECT g.appid, gc.category_id
+			FROM games g
+			LEFT JOIN game_category gc ON gc.appid = g.appid
+			WHERE g.appid IN (`+sqlPlaceholders(len(chunk), "?")+`)`, args...)
+		if err != nil {
+			return nil, fmt.Errorf("execute query (%d appids): %w", len(chunk), err)
+		}
 
-	for _, category := range categories {
-		assert(category < math.MaxUint16, category)
+		for rows.Next() {
+			var appID int
+			var categoryID sql.NullInt64
+			if err := rows.Scan(&appID, &categoryID); err != nil {
+				_ = rows.Close()
+				return nil, fmt.Errorf("scan: %w", err)
+			}
 
-		binary.LittleEndian.PutUint16(w, uint16(category))
-		w = w[2:]
+			categories := categoriesPerGame[appID]
+			if categoryID.Valid {
+				categories = append(categories, int(categoryID.Int64))
+			}
+			categoriesPerGame[appID] = categories
+		}
+		err = rows.Err()
+		_ = rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("rows: %w", err)
+		}
 	}
 
-	return encoded
+	return categoriesPerGame, nil
 }
 
-func queryGameCategories(db *sql.DB, appIDs []int) (map[int][]int, error) {
-	tx, err := db.Begin()
+func (s *libsqlStore) SaveGameCategories(categoriesPerGame map[int][]int) error {
+	tx, err := s.db.Begin()
 	if err != nil {
-		return nil, fmt.Errorf("begin transaction: %v", err)
+		return fmt.Errorf("begin transaction: %v", err)
 	}
 	defer func() {
 		_ = tx.Rollback()
 	}()
 
-	stmt, err := tx.Prepare("SELECT categories FROM game_categories WHERE appid = ?")
-	assert(err == nil, err)
+	now := time.Now().Unix()
 
-	categoriesPerGame := make(map[int][]int, len(appIDs))
+	appIDs := slices.Collect(maps.Keys(categoriesPerGame))
 
-	for _, appID := range appIDs {
-		var encodedCategories []byte
+	for chunk := range slices.Chunk(appIDs, dbQueryChunkSize/2) {
+		gameArgs := make([]any, 0, len(chunk)*2)
+		deleteArgs := make([]any, 0, len(chunk))
+		for _, appID := range chunk {
+			gameArgs = append(gameArgs, appID, now)
+			deleteArgs = append(deleteArgs, appID)
+		}
 
-		if err := stmt.QueryRow(appID).Scan(&encodedCategories); err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				continue
-			}
-			return nil, fmt.Errorf("execute query (appid=%d): %w", appID, err)
+		_, err = tx.Exec(`
+			INSERT INTO games (appid, categories_fetched_at) VALUES `+sqlPlaceholders(len(chunk), "(?, ?)")+`
+			ON CONFLICT (appid) DO UPDATE SET categories_fetched_at = excluded.categories_fetched_at`, gameArgs...)
+		if err != nil {
+			return fmt.Errorf("upsert games: %v", err)
+		}
+
+		_, err = tx.Exec("DELETE FROM game_category WHERE appid IN ("+sqlPlaceholders(len(chunk), "?")+")", deleteArgs...)
+		if err != nil {
+			return fmt.Errorf("delete old game categories: %v", err)
+		}
+	}
+
+	categoryArgs := make([]any, 0, dbQueryChunkSize)
+
+	flushCategories := func() error {
+		if len(categoryArgs) == 0 {
+			return nil
 		}
+		_, err := tx.Exec("INSERT OR IGNORE INTO game_category (appid, category_id) VALUES "+sqlPlaceholders(len(categoryArgs)/2, "(?, ?)"), categoryArgs...)
+		categoryArgs = categoryArgs[:0]
+		return err
+	}
+
+	for appID, categories := range categoriesPerGame {
+		for _, category := range categories {
+			categoryArgs = append(categoryArgs, appID, category)
 
-		categoriesPerGame[appID] = decodeDBGameCategories(encodedCategories)
+			if len(categoryArgs) == cap(categoryArgs) {
+				if err := flushCategories(); err != nil {
+					return fmt.Errorf("insert game categories: %v", err)
+				}
+			}
+		}
+	}
+	if err := flushCategories(); err != nil {
+		return fmt.Errorf("insert game categories: %v", err)
 	}
 
 	err = tx.Commit()
 	if err != nil {
-		return nil, fmt.Errorf("commit transaction: %v", err)
+		return fmt.Errorf("commit transaction: %v", err)
+	}
+
+	return nil
+}
+
+func (s *libsqlStore) GameCategoriesIn(categoryIDs []int) (map[int][]int, error) {
+	args := make([]any, len(categoryIDs))
+	for i, id := range categoryIDs {
+		args[i] = id
+	}
+
+	// "IN (NULL)" matches nothing
+	if len(args) == 0 {
+		args = append(args, nil)
+	}
+
+	// the games with none of them are included too
+	rows, err := s.db.Query(`
+		SELECT g.appid, gc.category_id
+		FROM games g
+		LEFT JOIN game_category gc ON gc.appid = g.appid AND gc.category_id IN (`+sqlPlaceholders(len(args), "?")+`)`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query: %v", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	categoriesPerGame := make(map[int][]int)
+
+	for rows.Next() {
+		var appID int
+		var categoryID sql.NullInt64
+		if err := rows.Scan(&appID, &categoryID); err != nil {
+			return nil, fmt.Errorf("scan: %v", err)
+		}
+		categories := categoriesPerGame[appID]
+		if categoryID.Valid {
+			categories = append(categories, int(categoryID.Int64))
+		}
+		categoriesPerGame[appID] = categories
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %v", err)
 	}
 
 	return categoriesPerGame, nil
 }
 
-func saveGameCategories(db *sql.DB, categoriesPerGame map[int][]int) error {
-	tx, err := db.Begin()
+func (s *libsqlStore) CategoryNames() (map[int]string, error) {
+	rows, err := s.db.Query("SELECT id, name FROM categories")
+	if err != nil {
+		return nil, fmt.Errorf("query: %v", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	names := make(map[int]string)
+
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, fmt.Errorf("scan: %v", err)
+		}
+		names[id] = name
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %v", err)
+	}
+
+	return names, nil
+}
+
+func (s *libsqlStore) SaveCategoryNames(names map[int]string) error {
+	if len(names) == 0 {
+		return nil
+	}
+
+	args := make([]any, 0, len(names)*2)
+	for id, name := range names {
+		args = append(args, id, name)
+	}
+
+	_, err := s.db.Exec(`
+		INSERT INTO categories (id, name) VALUES `+sqlPlaceholders(len(names), "(?, ?)")+`
+		ON CONFLICT (id) DO UPDATE SET name = excluded.name`, args...)
+	if err != nil {
+		return fmt.Errorf("exec: %v", err)
+	}
+	return nil
+}
+
+func (s *libsqlStore) Library(steamID string) (Library, bool, error) {
+	var fetchedAt int64
+	err := s.db.QueryRow("SELECT fetched_at FROM libraries WHERE steamid = ?", steamID).Scan(&fetchedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Library{}, false, nil
+		}
+		return Library{}, false, fmt.Errorf("query library: %v", err)
+	}
+
+	rows, err := s.db.Query("SELECT appid, name, playtime_2weeks, playtime_forever, price_status FROM library_games WHERE steamid = ?", steamID)
+	if err != nil {
+		return Library{}, false, fmt.Errorf("query library games: %v", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	library := Library{
+		Games:     make(map[int]SteamGame),
+		FetchedAt: time.Unix(fetchedAt, 0),
+	}
+
+	for rows.Next() {
+		var game SteamGame
+		if err := rows.Scan(&game.AppID, &game.Name, &game.Playtime2Weeks, &game.PlaytimeForever, &game.PriceStatus); err != nil {
+			return Library{}, false, fmt.Errorf("scan: %v", err)
+		}
+		library.Games[game.AppID] = game
+	}
+	if err := rows.Err(); err != nil {
+		return Library{}, false, fmt.Errorf("rows: %v", err)
+	}
+
+	return library, true, nil
+}
+
+func (s *libsqlStore) SaveLibrary(steamID string, library Library) error {
+	tx, err := s.db.Begin()
 	if err != nil {
 		return fmt.Errorf("begin transaction: %v", err)
 	}
@@ -105,13 +310,31 @@ func saveGameCategories(db *sql.DB, categoriesPerGame map[int][]int) error {
 		_ = tx.Rollback()
 	}()
 
-	stmt, err := tx.Prepare("INSERT INTO game_categories (appid, categories) VALUES (?, ?)")
-	assert(err == nil, err)
+	_, err = tx.Exec(`
+		INSERT INTO libraries (steamid, fetched_at) VALUES (?, ?)
+		ON CONFLICT (steamid) DO UPDATE SET fetched_at = excluded.fetched_at`, steamID, library.FetchedAt.Unix())
+	if err != nil {
+		return fmt.Errorf("upsert library: %v", err)
+	}
 
-	for appID, categories := range categoriesPerGame {
-		_, err = stmt.Exec(appID, encodeDBGameCategories(categories))
+	_, err = tx.Exec("DELETE FROM library_games WHERE steamid = ?", steamID)
+	if err != nil {
+		return fmt.Errorf("delete old library games: %v", err)
+	}
+
+	games := slices.Collect(maps.Values(library.Games))
+
+	for chunk := range slices.Chunk(games, dbQueryChunkSize/6) {
+		args := make([]any, 0, len(chunk)*6)
+		for _, game := range chunk {
+			args = append(args, steamID, game.AppID, game.Name, game.Playtime2Weeks, game.PlaytimeForever, game.PriceStatus)
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO library_games (steamid, appid, name, playtime_2weeks, playtime_forever, price_status)
+			VALUES `+sqlPlaceholders(len(chunk), "(?, ?, ?, ?, ?, ?)"), args...)
 		if err != nil {
-			return fmt.Errorf("exec: %v", err)
+			return fmt.Errorf("insert library games: %v", err)
 		}
 	}
 
@@ -122,3 +345,168 @@ func saveGameCategories(db *sql.DB, categoriesPerGame map[int][]int) error {
 
 	return nil
 }
+
+func (s *libsqlStore) SavePriceStatuses(statuses map[int]PriceStatus) error {
+	appIDsPerStatus := make(map[PriceStatus][]int)
+	for appID, status := range statuses {
+		appIDsPerStatus[status] = append(appIDsPerStatus[status], appID)
+	}
+
+	for status, appIDs := range appIDsPerStatus {
+		for chunk := range slices.Chunk(appIDs, dbQueryChunkSize-1) {
+			args := make([]any, 0, len(chunk)+1)
+			args = append(args, status)
+			for _, appID := range chunk {
+				args = append(args, appID)
+			}
+
+			_, err := s.db.Exec("UPDATE library_games SET price_status = ? WHERE appid IN ("+sqlPlaceholders(len(chunk), "?")+")", args...)
+			if err != nil {
+				return fmt.Errorf("exec: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *libsqlStore) Preference(steamID, key string) (string, bool, error) {
+	var value string
+	err := s.db.QueryRow("SELECT value FROM preferences WHERE steamid = ? AND key = ?", steamID, key).Scan(&value)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("query: %v", err)
+	}
+	return value, true, nil
+}
+
+func (s *libsqlStore) SavePreference(steamID, key, value string) error {
+	_, err := s.db.Exec(`
+		INSERT INTO preferences (steamid, key, value) VALUES (?, ?, ?)
+		ON CONFLICT (steamid, key) DO UPDATE SET value = excluded.value`, steamID, key, value)
+	if err != nil {
+		return fmt.Errorf("exec: %v", err)
+	}
+	return nil
+}
+
+func (s *libsqlStore) QueueCrawls(appIDs []int) error {
+	now := time.Now().Unix()
+
+	for chunk := range slices.Chunk(appIDs, dbQueryChunkSize/2) {
+		args := make([]any, 0, len(chunk)*2)
+		for _, appID := range chunk {
+			args = append(args, appID, now)
+		}
+
+		_, err := s.db.Exec("INSERT OR IGNORE INTO crawl_queue (appid, next_attempt_at) VALUES "+sqlPlaceholders(len(chunk), "(?, ?)"), args...)
+		if err != nil {
+			return fmt.Errorf("exec: %v", err)
+		}
+	}
+	return nil
+}
+
+func (s *libsqlStore) Crawl(appID int) (CrawlEntry, bool, error) {
+	entry := CrawlEntry{AppID: appID}
+
+	var nextAttemptAt int64
+	err := s.db.QueryRow("SELECT attempts, next_attempt_at, last_error FROM crawl_queue WHERE appid = ?", appID).Scan(&entry.Attempts, &nextAttemptAt, &entry.LastError)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return CrawlEntry{}, false, nil
+		}
+		return CrawlEntry{}, false, fmt.Errorf("query: %v", err)
+	}
+	entry.NextAttemptAt = time.Unix(nextAttemptAt, 0)
+
+	return entry, true, nil
+}
+
+func (s *libsqlStore) DueCrawls(now time.Time, limit int) ([]CrawlEntry, error) {
+	rows, err := s.db.Query(`
+		SELECT appid, attempts, next_attempt_at, last_error FROM crawl_queue
+		WHERE next_attempt_at <= ?
+		ORDER BY next_attempt_at
+		LIMIT ?`, now.Unix(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("query: %v", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var entries []CrawlEntry
+	for rows.Next() {
+		var entry CrawlEntry
+		var nextAttemptAt int64
+		if err := rows.Scan(&entry.AppID, &entry.Attempts, &nextAttemptAt, &entry.LastError); err != nil {
+			return nil, fmt.Errorf("scan: %v", err)
+		}
+		entry.NextAttemptAt = time.Unix(nextAttemptAt, 0)
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %v", err)
+	}
+
+	return entries, nil
+}
+
+func (s *libsqlStore) SaveCrawlAttempt(entry CrawlEntry) error {
+	_, err := s.db.Exec(`
+		INSERT OR REPLACE INTO crawl_queue (appid, attempts, next_attempt_at, last_error)
+		VALUES (?, ?, ?, ?)`, entry.AppID, entry.Attempts, entry.NextAttemptAt.Unix(), entry.LastError)
+	if err != nil {
+		return fmt.Errorf("exec: %v", err)
+	}
+	return nil
+}
+
+func (s *libsqlStore) DeleteCrawls(appIDs []int) error {
+	for chunk := range slices.Chunk(appIDs, dbQueryChunkSize) {
+		args := make([]any, len(chunk))
+		for i, appID := range chunk {
+			args[i] = appID
+		}
+
+		_, err := s.db.Exec("DELETE FROM crawl_queue WHERE appid IN ("+sqlPlaceholders(len(chunk), "?")+")", args...)
+		if err != nil {
+			return fmt.Errorf("exec: %v", err)
+		}
+	}
+	return nil
+}
+
+func (s *libsqlStore) Session(token string) (Session, bool, error) {
+	session := Session{Token: token}
+
+	var expiresAt int64
+	err := s.db.QueryRow("SELECT steamid, expires_at FROM sessions WHERE token = ? AND expires_at > ?", token, time.Now().Unix()).Scan(&session.SteamID, &expiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Session{}, false, nil
+		}
+		return Session{}, false, fmt.Errorf("query: %v", err)
+	}
+	session.ExpiresAt = time.Unix(expiresAt, 0)
+
+	return session, true, nil
+}
+
+func (s *libsqlStore) SaveSession(session Session) error {
+	_, err := s.db.Exec("INSERT OR REPLACE INTO sessions (token, steamid, expires_at) VALUES (?, ?, ?)", session.Token, session.SteamID, session.ExpiresAt.Unix())
+	if err != nil {
+		return fmt.Errorf("exec: %v", err)
+	}
+	return nil
+}
+
+func (s *libsqlStore) DeleteSession(token string) error {
+	_, err := s.db.Exec("DELETE FROM sessions WHERE token = ?", token)
+	if err != nil {
+		return fmt.Errorf("exec: %v", err)
+	}
+	return nil
+}
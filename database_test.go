@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/binary"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+)
+
+// countingConnector counts the statements sent to the database, each one is a round trip
+// to a remote one
+type countingConnector struct {
+	driver     driver.Driver
+	name       string
+	statements *atomic.Int64
+}
+
+func (c countingConnector) Connect(context.Context) (driver.Conn, error) {
+	conn, err := c.driver.Open(c.name)
+	if err != nil {
+		return nil, err
+	}
+	return countingConn{conn, c.statements}, nil
+}
+
+func (c countingConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+type countingConn struct {
+	driver.Conn
+	statements *atomic.Int64
+}
+
+func (c countingConn) Prepare(query string) (driver.Stmt, error) {
+	c.statements.Add(1)
+	return c.Conn.Prepare(query)
+}
+
+func (c countingConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return c.Conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+}
+
+func (c countingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.statements.Add(1)
+	return c.Conn.(driver.QueryerContext).QueryContext(ctx, query, args)
+}
+
+func (c countingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.statements.Add(1)
+	return c.Conn.(driver.ExecerContext).ExecContext(ctx, query, args)
+}
+
+// newBenchStore returns a migrated store in a temp file, and the counter of its statements
+func newBenchStore(b *testing.B) (*libsqlStore, *atomic.Int64) {
+	b.Helper()
+
+	url := "file:" + filepath.Join(b.TempDir(), "bench.db")
+
+	db, err := sql.Open("libsql", url)
+	if err != nil {
+		b.Fatal(err)
+	}
+	libsqlDriver := db.Driver()
+	_ = db.Close()
+
+	statements := &atomic.Int64{}
+	store := &libsqlStore{db: sql.OpenDB(countingConnector{libsqlDriver, url, statements})}
+	b.Cleanup(func() {
+		_ = store.Close()
+	})
+
+	_, err = migrateUp(store.db)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return store, statements
+}
+
+// encodeOldGameCategories encodes the categories as the blobs of the game_categories table,
+// a little-endian uint16 count followed by little-endian uint16 ids
+func encodeOldGameCategories(categories []int) []byte {
+	encoded := binary.LittleEndian.AppendUint16(nil, uint16(len(categories)))
+	for _, id := range categories {
+		encoded = binary.LittleEndian.AppendUint16(encoded, uint16(id))
+	}
+	return encoded
+}
+
+func TestMigrateGameCategoriesBlobs(t *testing.T) {
+	db, err := sql.Open("libsql", "file:"+filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	// a database from before the migrations only has the first table
+	_, err = db.Exec("CREATE TABLE game_categories (appid INT PRIMARY KEY, categories BLOB)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[int][]int{
+		10: {},
+		20: {1},
+		30: {2, 300, 65535},
+		40: {36, 38, 49, 256},
+	}
+	for appID, categories := range want {
+		_, err := db.Exec("INSERT INTO game_categories (appid, categories) VALUES (?, ?)", appID, encodeOldGameCategories(categories))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = migrateUp(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var covered []int
+	got := make(map[int][]int)
+
+	rows, err := db.Query("SELECT games.appid, category_id FROM games LEFT JOIN game_category USING (appid) ORDER BY games.appid, category_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var appID int
+		var categoryID sql.NullInt64
+		if err := rows.Scan(&appID, &categoryID); err != nil {
+			t.Fatal(err)
+		}
+		if len(covered) == 0 || covered[len(covered)-1] != appID {
+			covered = append(covered, appID)
+			got[appID] = []int{}
+		}
+		if categoryID.Valid {
+			got[appID] = append(got[appID], int(categoryID.Int64))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	_ = rows.Close()
+
+	if wantCovered := slices.Sorted(maps.Keys(want)); !slices.Equal(covered, wantCovered) {
+		t.Fatalf("games %v, want %v", covered, wantCovered)
+	}
+	for appID, categories := range want {
+		if !slices.Equal(got[appID], slices.Sorted(slices.Values(categories))) {
+			t.Errorf("appid %d: categories %v, want %v", appID, got[appID], categories)
+		}
+	}
+}
+
+func benchCategoriesPerGame(count int) map[int][]int {
+	categoriesPerGame := make(map[int][]int, count)
+	for appID := range count {
+		categoriesPerGame[appID] = []int{1, 2, 38}
+	}
+	return categoriesPerGame
+}
+
+var benchGroupSizes = []int{20, 200, 2000}
+
+func BenchmarkGameCategories(b *testing.B) {
+	for _, count := range benchGroupSizes {
+		b.Run(fmt.Sprintf("games=%d", count), func(b *testing.B) {
+			store, statements := newBenchStore(b)
+
+			categoriesPerGame := benchCategoriesPerGame(count)
+			err := store.SaveGameCategories(categoriesPerGame)
+			if err != nil {
+				b.Fatal(err)
+			}
+			appIDs := make([]int, 0, count)
+			for appID := range categoriesPerGame {
+				appIDs = append(appIDs, appID)
+			}
+
+			statements.Store(0)
+			b.ResetTimer()
+
+			for range b.N {
+				got, err := store.GameCategories(appIDs)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if len(got) != count {
+					b.Fatalf("got %d games, want %d", len(got), count)
+				}
+			}
+
+			b.ReportMetric(float64(statements.Load())/float64(b.N), "queries/op")
+		})
+	}
+}
+
+// BenchmarkGameCategoriesPerApp is the baseline, one query for each game in a transaction
+func BenchmarkGameCategoriesPerApp(b *testing.B) {
+	for _, count := range benchGroupSizes {
+		b.Run(fmt.Sprintf("games=%d", count), func(b *testing.B) {
+			store, statements := newBenchStore(b)
+
+			err := store.SaveGameCategories(benchCategoriesPerGame(count))
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			statements.Store(0)
+			b.ResetTimer()
+
+			for range b.N {
+				tx, err := store.db.Begin()
+				if err != nil {
+					b.Fatal(err)
+				}
+				for appID := range count {
+					rows, err := tx.Query("SELECT category_id FROM game_category WHERE appid = ?", appID)
+					if err != nil {
+						b.Fatal(err)
+					}
+					for rows.Next() {
+					}
+					_ = rows.Close()
+				}
+				_ = tx.Rollback()
+			}
+
+			b.ReportMetric(float64(statements.Load())/float64(b.N), "queries/op")
+		})
+	}
+}
+
+func BenchmarkSaveGameCategories(b *testing.B) {
+	for _, count := range benchGroupSizes {
+		b.Run(fmt.Sprintf("games=%d", count), func(b *testing.B) {
+			store, statements := newBenchStore(b)
+
+			categoriesPerGame := benchCategoriesPerGame(count)
+
+			statements.Store(0)
+			b.ResetTimer()
+
+			for range b.N {
+				err := store.SaveGameCategories(categoriesPerGame)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			b.ReportMetric(float64(statements.Load())/float64(b.N), "queries/op")
+		})
+	}
+}
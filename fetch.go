@@ -1,17 +1,20 @@
 package main
 
 import (
+	"cmp"
 	"context"
-	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"iter"
 	"log/slog"
+	"maps"
+	"math"
 	"net/http"
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"golang.org/x/sync/errgroup"
 )
@@ -22,6 +25,8 @@ type SteamUserInfo struct {
 	SteamID    string
 	Username   string
 	PictureURL string
+	// ISO 3166 code, empty if the profile doesn't show it
+	CountryCode string
 }
 
 func fetchSteamUsersInfo(steamAPIKey string, steamIDs []string, cache *CacheGroup) ([]SteamUserInfo, error) {
@@ -48,9 +53,10 @@ func fetchSteamUsersInfo(steamAPIKey string, steamIDs []string, cache *CacheGrou
 	type SteamResponse struct {
 		Response struct {
 			Players []struct {
-				SteamID    string `json:"steamid"`
-				Username   string `json:"personaname"`
-				PictureURL string `json:"avatarfull"`
+				SteamID     string `json:"steamid"`
+				Username    string `json:"personaname"`
+				PictureURL  string `json:"avatarfull"`
+				CountryCode string `json:"loccountrycode"`
 			} `json:"players"`
 		} `json:"response"`
 	}
@@ -83,18 +89,38 @@ type SteamGame struct {
 	Playtime2Weeks  int
 	PlaytimeForever int
 	Name            string
-	Free            bool
+	PriceStatus     PriceStatus
 }
 
-func fetchSteamUserOwnedGames(steamAPIKey, steamID string, cache *CacheGroup) (map[int]SteamGame, error) {
+// How long a library saved in the store is used before fetching it again
+const libraryMaxAge = 6 * time.Hour
+
+// How long to wait for a library, so a slow profile is left out instead of holding up the rest
+const libraryFetchTimeout = 10 * time.Second
+
+// fetchSteamUserLibrary returns the games of the user from the cache or the store, or from the
+// steam API if they are too old, in which case fresh is true and the prices of the games are
+// unknown yet.
+func fetchSteamUserLibrary(ctx context.Context, steamAPIKey, steamID string, store Store, cache *CacheGroup) (games map[int]SteamGame, fresh bool, err error) {
 	if games, ok := cache.games.Get(steamID); ok {
-		slog.Debug("fetchSteamUserOwnedGames: cache hit", "steamid", steamID)
-		return games, nil
+		slog.Debug("fetchSteamUserLibrary: cache hit", "steamid", steamID)
+		return games, false, nil
+	}
+
+	library, ok, err := store.Library(steamID)
+	if err != nil {
+		return nil, false, fmt.Errorf("get library from store: %v", err)
+	}
+	if ok && time.Since(library.FetchedAt) < libraryMaxAge {
+		slog.Debug("fetchSteamUserLibrary: store hit", "steamid", steamID)
+		cache.games.Set(steamID, library.Games)
+		return library.Games, false, nil
 	}
 
 	type SteamResponse struct {
 		Response struct {
-			Games []struct {
+			// missing for private profiles, empty for public ones with no games
+			Games *[]struct {
 				AppID           int    `json:"appid"`
 				Name            string `json:"name"`
 				Playtime2Weeks  int    `json:"playtime_2weeks"`
@@ -105,62 +131,202 @@ func fetchSteamUserOwnedGames(steamAPIKey, steamID string, cache *CacheGroup) (m
 
 	const URL = "https://api.steampowered.com/IPlayerService/GetOwnedGames/v0001?key=%s&steamid=%s&include_appinfo=true&include_played_free_games=true"
 
-	res, err := httpGet(fmt.Sprintf(URL, steamAPIKey, steamID))
+	res, err := httpContextDo(ctx, http.MethodGet, fmt.Sprintf(URL, steamAPIKey, steamID), nil)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 	defer func() {
 		_ = res.Body.Close()
 	}()
 
+	if res.StatusCode != http.StatusOK {
+		return nil, false, fmt.Errorf("unexpected status: %s", res.Status)
+	}
+
 	decoder := json.NewDecoder(res.Body)
 	var steamRes SteamResponse
 	err = decoder.Decode(&steamRes)
-	assert(err == nil, err)
-
-	appIDs := make([]int, len(steamRes.Response.Games))
-	for i, g := range steamRes.Response.Games {
-		appIDs[i] = g.AppID
+	if err != nil {
+		return nil, false, fmt.Errorf("decode response: %v", err)
 	}
 
-	prices, err := fetchSteamGamesPrices(appIDs, cache)
-	if err != nil {
-		return nil, fmt.Errorf("fetch game prices: %v", err)
+	if steamRes.Response.Games == nil {
+		return nil, false, errors.New("the profile or its game details are private")
 	}
 
-	games := make(map[int]SteamGame, len(steamRes.Response.Games))
-	for _, g := range steamRes.Response.Games {
+	games = make(map[int]SteamGame, len(*steamRes.Response.Games))
+	for _, g := range *steamRes.Response.Games {
 		games[g.AppID] = SteamGame{
 			AppID:           g.AppID,
 			Name:            g.Name,
-			Free:            prices[g.AppID].Free(),
 			Playtime2Weeks:  g.Playtime2Weeks,
 			PlaytimeForever: g.PlaytimeForever,
 		}
 	}
 
-	cache.games.Set(steamID, games)
-	return games, nil
+	return games, true, nil
 }
 
+// fetchSteamUsersOwnedGames fetches the libraries in parallel, and the prices of the fresh
+// ones all at once. The users whose library couldn't be fetched are returned with the error.
+func fetchSteamUsersOwnedGames(steamAPIKey string, steamIDs []string, store Store, cache *CacheGroup) (usersGames map[string]map[int]SteamGame, failed map[string]error, err error) {
+	type Result struct {
+		Games map[int]SteamGame
+		Fresh bool
+		Err   error
+	}
+
+	results := make([]Result, len(steamIDs))
+
+	eg := errgroup.Group{}
+	eg.SetLimit(4)
+
+	for i, steamID := range steamIDs {
+		eg.Go(func() error {
+			ctx, cancel := context.WithTimeout(context.Background(), libraryFetchTimeout)
+			defer cancel()
+
+			r := &results[i]
+			r.Games, r.Fresh, r.Err = fetchSteamUserLibrary(ctx, steamAPIKey, steamID, store, cache)
+			return nil
+		})
+	}
+
+	_ = eg.Wait()
+
+	usersGames = make(map[string]map[int]SteamGame, len(steamIDs))
+	failed = make(map[string]error)
+	var priceAppIDs []int
+
+	for i, steamID := range steamIDs {
+		r := results[i]
+		if r.Err != nil {
+			failed[steamID] = r.Err
+			continue
+		}
+		usersGames[steamID] = r.Games
+
+		// the prices that were unknown are tried again
+		for appID, game := range r.Games {
+			if r.Fresh || game.PriceStatus == PriceUnknown {
+				priceAppIDs = append(priceAppIDs, appID)
+			}
+		}
+	}
+
+	if len(priceAppIDs) == 0 {
+		return usersGames, failed, nil
+	}
+
+	slices.Sort(priceAppIDs)
+	priceAppIDs = slices.Compact(priceAppIDs)
+
+	// the status is taken from the region of the server
+	prices, err := fetchSteamGamesPrices(priceAppIDs, "", cache)
+	if err != nil {
+		// they stay unknown until the next try
+		slog.Warn("fetch game prices", "count", len(priceAppIDs), "err", err)
+	}
+
+	resolved := make(map[int]PriceStatus)
+
+	for i, steamID := range steamIDs {
+		r := results[i]
+		if r.Err != nil {
+			continue
+		}
+
+		if r.Fresh {
+			for appID, game := range r.Games {
+				game.PriceStatus = prices[appID].Status
+				r.Games[appID] = game
+			}
+
+			err = store.SaveLibrary(steamID, Library{Games: r.Games, FetchedAt: time.Now()})
+			if err != nil {
+				return nil, nil, fmt.Errorf("save library to store (steamid=%s): %v", steamID, err)
+			}
+			cache.games.Set(steamID, r.Games)
+			continue
+		}
+
+		// the cached library is shared with other requests, so it's replaced instead
+		var games map[int]SteamGame
+		for appID, game := range r.Games {
+			price, ok := prices[appID]
+			if !ok || game.PriceStatus != PriceUnknown {
+				continue
+			}
+			if games == nil {
+				games = maps.Clone(r.Games)
+			}
+			game.PriceStatus = price.Status
+			games[appID] = game
+			resolved[appID] = price.Status
+		}
+		if games != nil {
+			usersGames[steamID] = games
+			cache.games.Set(steamID, games)
+		}
+	}
+
+	if len(resolved) > 0 {
+		err = store.SavePriceStatuses(resolved)
+		if err != nil {
+			return nil, nil, fmt.Errorf("save price statuses to store: %v", err)
+		}
+	}
+
+	return usersGames, failed, nil
+}
+
+// PriceStatus is what is known about the price of a game, only known free games are
+// treated as free. The values are stored in the database.
+type PriceStatus uint8
+
+const (
+	// not fetched yet, or missing from the response
+	PriceUnknown PriceStatus = iota
+	PriceFree
+	PricePaid
+	// delisted or not sold in the region
+	PriceUnavailable
+)
+
+// SteamGamePrice has the amounts in cents, in every currency
 type SteamGamePrice struct {
-	Currency        string
-	Initial         int
-	Final           int
-	DiscountPercent int
+	Status           PriceStatus
+	Currency         string
+	Initial          int
+	Final            int
+	DiscountPercent  int
+	InitialFormatted string
+	FinalFormatted   string
 }
 
 func (p SteamGamePrice) Free() bool {
-	return p.Initial == 0
+	return p.Status == PriceFree
+}
+
+func (p SteamGamePrice) Unavailable() bool {
+	return p.Status == PriceUnavailable
+}
+
+// formatPrice formats an amount in cents that steam didn't format, like a sum of prices
+func formatPrice(amount int, currency string) string {
+	return fmt.Sprintf("%d.%02d %s", amount/100, amount%100, currency)
 }
 
 type _fetchSteamPriceData struct {
 	value struct {
-		PriceOverview struct {
-			Currency        string `json:"currency"`
-			Initial         int    `json:"initial"`
-			Final           int    `json:"final"`
-			DiscountPercent int    `json:"discount_percent"`
+		// free games have none
+		PriceOverview *struct {
+			Currency         string `json:"currency"`
+			Initial          int    `json:"initial"`
+			Final            int    `json:"final"`
+			DiscountPercent  int    `json:"discount_percent"`
+			InitialFormatted string `json:"initial_formatted"`
+			FinalFormatted   string `json:"final_formatted"`
 		} `json:"price_overview"`
 	}
 }
@@ -174,66 +340,270 @@ func (d *_fetchSteamPriceData) UnmarshalJSON(data []byte) error {
 	return json.Unmarshal(data, &d.value)
 }
 
-func fetchSteamGamesPrices(appIDs []int, cache *CacheGroup) (map[int]SteamGamePrice, error) {
-	prices := make(map[int]SteamGamePrice, len(appIDs))
+// Prices are fetched in chunks of this many apps per request, so the URL stays short.
+// $PRICE_CHUNK_SIZE overrides it.
+var priceChunkSize = 100
 
-	type SteamResponse = map[string]struct {
-		Success bool                 `json:"success"`
-		Data    _fetchSteamPriceData `json:"data"`
+// The steam store allows about 200 requests every 5 minutes, for prices and app details
+// together
+var storeAPILimiter = newRateLimiter(1500*time.Millisecond, 10)
+
+// Apps missing from a price response are not requested again before this
+const priceRetryDelay = 10 * time.Minute
+
+// priceKey is a price in a region, the country code is empty for the region of the server
+type priceKey struct {
+	AppID       int
+	CountryCode string
+}
+
+// isCountryCode reports whether cc is a country code as sent to the steam store
+func isCountryCode(cc string) bool {
+	if len(cc) != 2 {
+		return false
 	}
+	for _, c := range cc {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
 
-	const URL = "https://store.steampowered.com/api/appdetails?appids=%s&filters=price_overview"
+// fetchSteamGamesPrices returns the prices in the currency of the country it knows of, the
+// ones that could not be fetched are missing and are retried after priceRetryDelay. It
+// only fails if no request succeeded.
+func fetchSteamGamesPrices(appIDs []int, countryCode string, cache *CacheGroup) (map[int]SteamGamePrice, error) {
+	assert(countryCode == "" || isCountryCode(countryCode), countryCode)
 
-	appIDsArg := strings.Builder{}
+	prices := make(map[int]SteamGamePrice, len(appIDs))
+	var uncachedAppIDs []int
+	var waitingCount int
+
+	now := time.Now()
 	for _, id := range appIDs {
-		if price, ok := cache.prices.Get(id); ok {
+		key := priceKey{id, countryCode}
+		if price, ok := cache.prices.Get(key); ok {
 			prices[id] = price
 			continue
 		}
-		appIDsArg.WriteString(fmt.Sprint(id))
-		appIDsArg.WriteByte(',')
+		if retryAt, ok := cache.priceRetries.Get(key); ok && now.Before(retryAt) {
+			waitingCount++
+			continue
+		}
+		uncachedAppIDs = append(uncachedAppIDs, id)
 	}
 
-	if len(prices) == len(appIDs) {
-		slog.Debug("fetchSteamGamesPrices: full cache hit", "count", len(prices))
+	if len(uncachedAppIDs) == 0 {
+		slog.Debug("fetchSteamGamesPrices: full cache hit", "count", len(prices), "waiting", waitingCount, "cc", countryCode)
 		return prices, nil
 	}
 	if len(prices) > 0 {
 		slog.Debug("fetchSteamGamesPrices: partial cache hit", "count", len(prices))
 	}
 
-	gamesLeftCount := len(appIDs) - len(prices)
+	chunks := slices.Collect(slices.Chunk(uncachedAppIDs, priceChunkSize))
+	fetched := make(map[int]SteamGamePrice, len(uncachedAppIDs))
+	var failedCount int
+	var lastErr error
+	mu := sync.Mutex{}
+
+	eg := errgroup.Group{}
+	eg.SetLimit(4)
+
+	for _, chunk := range chunks {
+		eg.Go(func() error {
+			storeAPILimiter.Wait()
+			chunkPrices, err := fetchSteamGamesPricesChunk(chunk, countryCode)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err != nil {
+				slog.Warn("fetch game prices chunk", "count", len(chunk), "cc", countryCode, "err", err)
+				failedCount++
+				lastErr = err
+				return nil
+			}
+			maps.Copy(fetched, chunkPrices)
+			return nil
+		})
+	}
+
+	_ = eg.Wait()
+
+	retryAt := time.Now().Add(priceRetryDelay)
+	var missingCount int
+	for _, appID := range uncachedAppIDs {
+		key := priceKey{appID, countryCode}
+		price, ok := fetched[appID]
+		if !ok {
+			cache.priceRetries.Set(key, retryAt)
+			missingCount++
+			continue
+		}
+		prices[appID] = price
+		cache.prices.Set(key, price)
+	}
+
+	if failedCount == len(chunks) {
+		return nil, fmt.Errorf("all %d requests failed, last one: %v", failedCount, lastErr)
+	}
+
+	slog.Debug("fetchSteamGamesPrices: fetched from steam api", "count", len(fetched), "missing", missingCount, "chunks", len(chunks), "cc", countryCode)
+
+	return prices, nil
+}
+
+// fetchMissingGamesPrices returns the prices of the paid games for the members that don't
+// own them, country code -> appid -> price, in one call of fetchSteamGamesPrices for each
+// country. The ones that could not be fetched are missing.
+func fetchMissingGamesPrices(games []GroupGame, countryCodes map[string]string, cache *CacheGroup) map[string]map[int]SteamGamePrice {
+	appIDsByCountry := make(map[string][]int)
+	for _, game := range games {
+		if game.Free() {
+			continue
+		}
+		for _, member := range game.Members {
+			if !member.Owned {
+				countryCode := countryCodes[member.SteamID]
+				appIDsByCountry[countryCode] = append(appIDsByCountry[countryCode], game.AppID)
+			}
+		}
+	}
+
+	pricesByCountry := make(map[string]map[int]SteamGamePrice, len(appIDsByCountry))
+	mu := sync.Mutex{}
+
+	eg := errgroup.Group{}
+	for countryCode, appIDs := range appIDsByCountry {
+		eg.Go(func() error {
+			slices.Sort(appIDs)
+			prices, err := fetchSteamGamesPrices(slices.Compact(appIDs), countryCode, cache)
+			if err != nil {
+				slog.Warn("fetch missing game prices", "count", len(appIDs), "cc", countryCode, "err", err)
+			}
+
+			mu.Lock()
+			pricesByCountry[countryCode] = prices
+			mu.Unlock()
+			return nil
+		})
+	}
+	_ = eg.Wait()
+
+	return pricesByCountry
+}
+
+// fetchSteamGamesPricesChunk fetches the prices in a single request, the apps missing from
+// the response are missing from prices
+func fetchSteamGamesPricesChunk(appIDs []int, countryCode string) (map[int]SteamGamePrice, error) {
+	type SteamResponse = map[string]struct {
+		Success bool                 `json:"success"`
+		Data    _fetchSteamPriceData `json:"data"`
+	}
 
-	res, err := httpGet(fmt.Sprintf(URL, appIDsArg.String()))
+	const URL = "https://store.steampowered.com/api/appdetails?appids=%s&filters=price_overview"
+
+	appIDsArg := strings.Builder{}
+	for _, id := range appIDs {
+		appIDsArg.WriteString(fmt.Sprint(id))
+		appIDsArg.WriteByte(',')
+	}
+
+	reqURL := fmt.Sprintf(URL, appIDsArg.String())
+	if len(countryCode) > 0 {
+		reqURL += "&cc=" + countryCode
+	}
+
+	res, err := httpGet(reqURL)
 	if err != nil {
 		return nil, err
 	}
 	defer func() {
 		_ = res.Body.Close()
 	}()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", res.Status)
+	}
+
 	decoder := json.NewDecoder(res.Body)
 	var steamRes SteamResponse
 	err = decoder.Decode(&steamRes)
-	assert(err == nil, err)
+	if err != nil {
+		return nil, fmt.Errorf("decode response: %v", err)
+	}
 
+	prices := make(map[int]SteamGamePrice, len(steamRes))
 	for appIDStr, data := range steamRes {
-		if !data.Success {
-			continue
-		}
 		appID, err := strconv.ParseInt(appIDStr, 10, 64)
 		if err != nil {
 			return nil, fmt.Errorf("parse appid: %v", err)
 		}
 
-		price := SteamGamePrice(data.Data.value.PriceOverview)
+		price := SteamGamePrice{Status: PriceUnavailable}
+		if data.Success {
+			price.Status = PriceFree
+			if overview := data.Data.value.PriceOverview; overview != nil {
+				price = SteamGamePrice{
+					Status:           PricePaid,
+					Currency:         overview.Currency,
+					Initial:          overview.Initial,
+					Final:            overview.Final,
+					DiscountPercent:  overview.DiscountPercent,
+					InitialFormatted: overview.InitialFormatted,
+					FinalFormatted:   overview.FinalFormatted,
+				}
+			}
+		}
 		prices[int(appID)] = price
+	}
+
+	return prices, nil
+}
 
-		cache.prices.Set(int(appID), price)
+// rateLimiter lets burst calls through at once, and then one every interval
+type rateLimiter struct {
+	interval time.Duration
+	burst    int
+	// when the next call would go through if there was no burst
+	next time.Time
+	mu   sync.Mutex
+}
+
+func newRateLimiter(interval time.Duration, burst int) *rateLimiter {
+	return &rateLimiter{interval: interval, burst: burst}
+}
+
+// Wait blocks until the call is allowed
+func (l *rateLimiter) Wait() {
+	l.mu.Lock()
+	now := time.Now()
+	next := l.next
+	if next.Before(now) {
+		next = now
 	}
+	l.next = next.Add(l.interval)
+	l.mu.Unlock()
 
-	slog.Debug("fetchSteamGamesPrices: fetched from steam api", "count", gamesLeftCount)
+	delay := next.Sub(now) - l.interval*time.Duration(l.burst-1)
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
 
-	return prices, nil
+// Available is the number of calls that would go through now without waiting
+func (l *rateLimiter) Available() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ahead := l.next.Sub(time.Now())
+	if ahead <= 0 {
+		return l.burst
+	}
+	reserved := int((ahead + l.interval - 1) / l.interval)
+	return max(l.burst-reserved, 0)
 }
 
 func fetchSteamUserFriends(steamAPIKey, steamID string, cache *CacheGroup) ([]string, error) {
@@ -327,7 +697,8 @@ func fetchSteamGameCategories(ctx context.Context, appID int, cache *CacheGroup)
 		Success bool `json:"success"`
 		Data    struct {
 			Categories []struct {
-				ID int `json:"id"`
+				ID          int    `json:"id"`
+				Description string `json:"description"`
 			} `json:"categories"`
 		} `json:"data"`
 	}
@@ -342,47 +713,62 @@ func fetchSteamGameCategories(ctx context.Context, appID int, cache *CacheGroup)
 		_ = res.Body.Close()
 	}()
 
+	// the limit of the store API is answered with 429
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", res.Status)
+	}
+
 	decoder := json.NewDecoder(res.Body)
 	var steamRes SteamResponse
 	err = decoder.Decode(&steamRes)
 	if err != nil {
-		err, ok := err.(*json.UnmarshalTypeError)
-		assert(ok, err)
+		// like a timeout while reading the body
+		var typeErr *json.UnmarshalTypeError
+		if !errors.As(err, &typeErr) {
+			return nil, fmt.Errorf("decode response: %v", err)
+		}
 
-		if err.Value == "array" && err.Field == "data" {
-			cache.gameCategories.Set(appID, nil)
+		// apps without details have an empty array as data, other type errors keep what
+		// was decoded
+		if typeErr.Value == "array" && typeErr.Field == "data" {
+			setGameCategories(appID, nil, cache)
 			return nil, nil
 		}
-		assert(true, err)
 	}
 
 	gameRes, ok := steamRes[fmt.Sprint(appID)]
 	if !ok {
-		cache.gameCategories.Set(appID, nil)
+		setGameCategories(appID, nil, cache)
 		return nil, nil
 	}
 
 	if !gameRes.Success {
-		cache.gameCategories.Set(appID, nil)
+		setGameCategories(appID, nil, cache)
 		return nil, nil
 	}
 
 	categories := make([]int, len(gameRes.Data.Categories))
 	for i, category := range gameRes.Data.Categories {
 		categories[i] = category.ID
+
+		if name, ok := cache.categoryNames.Get(category.ID); !ok || name != category.Description {
+			cache.categoryNames.Set(category.ID, category.Description)
+		}
 	}
 
-	cache.gameCategories.Set(appID, categories)
+	setGameCategories(appID, categories, cache)
 
 	return categories, nil
 }
 
-func fetchGamesCategories(appIDs []int, dst map[int][]int, db *sql.DB, cache *CacheGroup) error {
+// fetchGamesCategories calls onFetched as soon as the categories of each game are known,
+// the ones that are not in the cache nor the store are queued in the crawler with priority.
+func fetchGamesCategories(appIDs []int, priority CrawlPriority, onFetched func(appID int, categories []int), store Store, cache *CacheGroup, crawler *CategoryCrawler) error {
 	queryAppIDs := make([]int, 0, len(appIDs))
 
 	for _, appID := range appIDs {
 		if categories, ok := cache.gameCategories.Get(appID); ok {
-			dst[appID] = categories
+			onFetched(appID, categories)
 			continue
 		}
 		queryAppIDs = append(queryAppIDs, appID)
@@ -396,7 +782,7 @@ func fetchGamesCategories(appIDs []int, dst map[int][]int, db *sql.DB, cache *Ca
 		slog.Debug("fetchGamesCategories: partial local cache hit", "count", len(appIDs)-len(queryAppIDs))
 	}
 
-	categoriesFromDB, err := queryGameCategories(db, queryAppIDs)
+	categoriesFromDB, err := store.GameCategories(queryAppIDs)
 	if err != nil {
 		return fmt.Errorf("query from db: %v", err)
 	}
@@ -407,8 +793,8 @@ func fetchGamesCategories(appIDs []int, dst map[int][]int, db *sql.DB, cache *Ca
 
 	for _, appID := range gamesLeft {
 		if categories, ok := categoriesFromDB[appID]; ok {
-			cache.gameCategories.Set(appID, categories)
-			dst[appID] = categories
+			setGameCategories(appID, categories, cache)
+			onFetched(appID, categories)
 			continue
 		}
 		queryAppIDs = append(queryAppIDs, appID)
@@ -422,180 +808,398 @@ func fetchGamesCategories(appIDs []int, dst map[int][]int, db *sql.DB, cache *Ca
 		slog.Debug("fetchGamesCategories: partial db cache hit", "count", len(gamesLeft)-len(queryAppIDs))
 	}
 
-	newCategories := make(map[int][]int, len(queryAppIDs))
+	results := crawler.Subscribe(queryAppIDs, priority)
 
-	// Test if the steam API limit was reached
-	testAppID := queryAppIDs[len(queryAppIDs)-1]
-	queryAppIDs = queryAppIDs[:len(queryAppIDs)-1]
+	for range queryAppIDs {
+		result := <-results
+		if result.Err != nil {
+			return fmt.Errorf("fetch steam game categories (appid=%d): %v", result.AppID, result.Err)
+		}
+		onFetched(result.AppID, result.Categories)
+	}
 
-	categories, err := fetchSteamGameCategories(context.Background(), testAppID, cache)
-	if err != nil {
-		return fmt.Errorf("fetch steam game categories (appid=%d): steam api limit probably reached: %v", testAppID, err)
-	} else {
-		newCategories[testAppID] = categories
-		dst[testAppID] = categories
+	slog.Debug("fetchGamesCategories: fetched games from steam api", "count", len(queryAppIDs))
+
+	return nil
+}
+
+type SortMode string
+
+const (
+	// the logged in user's recent playtime, then total playtime
+	SortModeYours         SortMode = "yours"
+	SortModeGroupPlaytime SortMode = "group-playtime"
+	SortModeNewToGroup    SortMode = "new-to-group"
+	SortModeRecent        SortMode = "recent"
+	SortModeAlphabetical  SortMode = "alphabetical"
+	// the playtime of the member that played it the least
+	SortModeFairness SortMode = "fairness"
+	// what the members that don't own it would spend, in the region of the server
+	SortModeCheapest SortMode = "cheapest"
+)
+
+var sortModes = []struct {
+	Mode  SortMode
+	Label string
+}{
+	{SortModeYours, "Your playtime"},
+	{SortModeGroupPlaytime, "Group playtime"},
+	{SortModeNewToGroup, "Something new"},
+	{SortModeRecent, "Recently played"},
+	{SortModeAlphabetical, "Alphabetical"},
+	{SortModeFairness, "Fairest"},
+	{SortModeCheapest, "Cheapest to complete"},
+}
+
+func parseSortMode(mode string) (SortMode, bool) {
+	if len(mode) == 0 {
+		return SortModeYours, true
+	}
+	for _, m := range sortModes {
+		if string(m.Mode) == mode {
+			return m.Mode, true
+		}
 	}
+	return "", false
+}
 
-	// Fetch the rest
-	eg, ctx := errgroup.WithContext(context.Background())
-	eg.SetLimit(10)
+type GroupMember struct {
+	SteamID         string
+	Owned           bool
+	Playtime2Weeks  int
+	PlaytimeForever int
+}
 
-	for _, appID := range queryAppIDs {
-		eg.Go(func() error {
-			categories, err := fetchSteamGameCategories(ctx, appID, cache)
-			if err != nil {
-				return fmt.Errorf("appid %d: %v", appID, err)
-			}
-			newCategories[appID] = categories
-			dst[appID] = categories
-			return nil
-		})
+// GroupGame is a game as seen by every member of the group, members that don't own it
+// have no playtime.
+type GroupGame struct {
+	AppID       int
+	Name        string
+	PriceStatus PriceStatus
+	Members     []GroupMember
+}
+
+func newGroupGame(appID int, users []string, owners OwnersMask, usersGames map[string]map[int]SteamGame) GroupGame {
+	groupGame := GroupGame{
+		AppID:   appID,
+		Members: make([]GroupMember, len(users)),
 	}
 
-	fetchErr := eg.Wait()
+	for i, userID := range users {
+		member := GroupMember{SteamID: userID}
 
-	assert(len(newCategories) > 0)
-	slog.Debug("fetchGamesCategories: fetched games from steam api", "count", len(newCategories))
+		if owners.Has(i) {
+			game := usersGames[userID][appID]
+			groupGame.Name = game.Name
+			groupGame.PriceStatus = game.PriceStatus
 
-	err = saveGameCategories(db, newCategories)
-	if err != nil {
-		return fmt.Errorf("save new game categories to database: %v", err)
+			member.Owned = true
+			member.Playtime2Weeks = game.Playtime2Weeks
+			member.PlaytimeForever = game.PlaytimeForever
+		}
+
+		groupGame.Members[i] = member
 	}
 
-	if fetchErr != nil {
-		return fmt.Errorf("fetch steam game categories: %v", fetchErr)
+	return groupGame
+}
+
+// Free is only true if the game is known to be free, so everyone can play it
+func (g GroupGame) Free() bool {
+	return g.PriceStatus == PriceFree
+}
+
+func (g GroupGame) Member(steamID string) (GroupMember, bool) {
+	for _, member := range g.Members {
+		if member.SteamID == steamID {
+			return member, true
+		}
 	}
-	return nil
+	return GroupMember{}, false
 }
 
-func newFetchGameCategoriesIter(games []SteamGame, gamesPerPage int, db *sql.DB, cache *CacheGroup) iter.Seq2[struct {
-	game       SteamGame
-	categories []int
-}, error] {
-	type YieldValue = struct {
-		game       SteamGame
-		categories []int
+// Host is the owner who played it the most, for the others to join with Remote Play Together
+func (g GroupGame) Host() (GroupMember, bool) {
+	host, found := GroupMember{}, false
+	for _, member := range g.Members {
+		if member.Owned && (!found || member.PlaytimeForever > host.PlaytimeForever) {
+			host, found = member, true
+		}
 	}
+	return host, found
+}
 
-	categoriesPerGame := make(map[int][]int, len(games))
+func (g GroupGame) Owners() []string {
+	owners := make([]string, 0, len(g.Members))
+	for _, member := range g.Members {
+		if member.Owned {
+			owners = append(owners, member.SteamID)
+		}
+	}
+	return owners
+}
 
-	return func(yield func(YieldValue, error) bool) {
-		for i, game := range games {
+func (g GroupGame) TotalPlaytime() int {
+	total := 0
+	for _, member := range g.Members {
+		total += member.PlaytimeForever
+	}
+	return total
+}
 
-			if i == len(categoriesPerGame) {
-				appIDs := make([]int, min(gamesPerPage, len(games)-i))
+func (g GroupGame) MinPlaytime() int {
+	minPlaytime := -1
+	for _, member := range g.Members {
+		if minPlaytime == -1 || member.PlaytimeForever < minPlaytime {
+			minPlaytime = member.PlaytimeForever
+		}
+	}
+	return max(minPlaytime, 0)
+}
 
-				for j := range gamesPerPage {
-					if i+j >= len(games) {
-						break
-					}
-					appIDs[j] = games[i+j].AppID
-				}
+// CostToComplete is what the members that don't own it would spend at this price, it's
+// not ok if the price is needed but not known
+func (g GroupGame) CostToComplete(price SteamGamePrice) (cost int, ok bool) {
+	missingCount := len(g.Members) - len(g.Owners())
+	if missingCount == 0 || g.Free() {
+		return 0, true
+	}
+	if price.Status != PricePaid {
+		return 0, false
+	}
+	return missingCount * price.Final, true
+}
+
+func (g GroupGame) MaxPlaytime2Weeks() int {
+	maxPlaytime := 0
+	for _, member := range g.Members {
+		maxPlaytime = max(maxPlaytime, member.Playtime2Weeks)
+	}
+	return maxPlaytime
+}
 
-				err := fetchGamesCategories(appIDs, categoriesPerGame, db, cache)
-				if err != nil {
-					yield(YieldValue{}, err)
-					return
+// sortGames only needs the prices in SortModeCheapest
+func sortGames(games []GroupGame, mode SortMode, steamID string, prices map[int]SteamGamePrice) {
+	byName := func(a, b GroupGame) int {
+		return cmp.Or(strings.Compare(a.Name, b.Name), cmp.Compare(a.AppID, b.AppID))
+	}
+
+	switch mode {
+	case SortModeYours:
+		slices.SortFunc(games, func(a, b GroupGame) int {
+			aMember, _ := a.Member(steamID)
+			bMember, _ := b.Member(steamID)
+
+			if aMember.Playtime2Weeks > 0 || bMember.Playtime2Weeks > 0 {
+				switch {
+				case aMember.Playtime2Weeks > bMember.Playtime2Weeks:
+					return -1
+				case aMember.Playtime2Weeks < bMember.Playtime2Weeks:
+					return 1
+				case a.Name > b.Name:
+					return -1
 				}
+				return 1
 			}
 
-			categories, ok := categoriesPerGame[game.AppID]
-			if !ok {
-				continue
+			switch {
+			case aMember.PlaytimeForever > bMember.PlaytimeForever:
+				return -1
+			case aMember.PlaytimeForever < bMember.PlaytimeForever:
+				return 1
+			case a.Name > b.Name:
+				return -1
 			}
+			return 1
+		})
+
+	case SortModeGroupPlaytime:
+		slices.SortFunc(games, func(a, b GroupGame) int {
+			return cmp.Or(cmp.Compare(b.TotalPlaytime(), a.TotalPlaytime()), byName(a, b))
+		})
 
-			if !yield(YieldValue{game, categories}, nil) {
-				return
+	case SortModeNewToGroup:
+		slices.SortFunc(games, func(a, b GroupGame) int {
+			return cmp.Or(cmp.Compare(a.TotalPlaytime(), b.TotalPlaytime()), byName(a, b))
+		})
+
+	case SortModeRecent:
+		slices.SortFunc(games, func(a, b GroupGame) int {
+			return cmp.Or(
+				cmp.Compare(b.MaxPlaytime2Weeks(), a.MaxPlaytime2Weeks()),
+				cmp.Compare(b.TotalPlaytime(), a.TotalPlaytime()),
+				byName(a, b),
+			)
+		})
+
+	case SortModeAlphabetical:
+		slices.SortFunc(games, byName)
+
+	case SortModeFairness:
+		slices.SortFunc(games, func(a, b GroupGame) int {
+			return cmp.Or(
+				cmp.Compare(b.MinPlaytime(), a.MinPlaytime()),
+				cmp.Compare(b.TotalPlaytime(), a.TotalPlaytime()),
+				byName(a, b),
+			)
+		})
+
+	case SortModeCheapest:
+		// the ones whose price is not known go last
+		cost := func(game GroupGame) int {
+			cost, ok := game.CostToComplete(prices[game.AppID])
+			if !ok {
+				return math.MaxInt
 			}
+			return cost
 		}
+		slices.SortFunc(games, func(a, b GroupGame) int {
+			return cmp.Or(
+				cmp.Compare(cost(a), cost(b)),
+				cmp.Compare(b.TotalPlaytime(), a.TotalPlaytime()),
+				byName(a, b),
+			)
+		})
+
+	default:
+		panic("invalid sort mode: " + mode)
 	}
 }
 
-func getSteamSortedGames(steamAPIKey string, steamID string, users []string, cache *CacheGroup) ([]SteamGame, error) {
+func getSortedGamesCacheKey(steamID string, users []string, sortMode SortMode, minOwners int, remotePlay bool) string {
 	sortedUsers := slices.Sorted(slices.Values(users))
-	cacheKey := strings.Join(sortedUsers, ",")
+	return fmt.Sprintf("%s:%d:%t:%s:%s", sortMode, minOwners, remotePlay, steamID, strings.Join(sortedUsers, ","))
+}
+
+// getSteamSortedGames returns the games that at least minOwners members own, and the free
+// games anyone owns. With remotePlay, it returns the games anyone owns, so the games list
+// can keep the Remote Play Together ones.
+//
+// The games owned by more members go first when not everyone has to own them, or with
+// remotePlay. The cost already counts the owners, so the cheapest sort is left as is.
+//
+// The members whose library couldn't be fetched are left out and returned. minOwners is
+// capped to the members left. The result is not cacheable then, nor when some prices to
+// sort by cost are not known yet.
+func getSteamSortedGames(steamAPIKey string, steamID string, users []string, sortMode SortMode, minOwners int, remotePlay bool, store Store, cache *CacheGroup) (sortedGames []GroupGame, failedUsers []string, cacheable bool, err error) {
+	cacheKey := getSortedGamesCacheKey(steamID, users, sortMode, minOwners, remotePlay)
 
 	if sortedGames, ok := cache.sortedGames.Get(cacheKey); ok {
 		slog.Debug("handleGames: cache hit", "users", cacheKey)
-		return sortedGames, nil
+		return sortedGames, nil, true, nil
 	}
 
-	usersGames := make(map[string]map[int]SteamGame, len(users))
-	for _, id := range users {
-		games, err := fetchSteamUserOwnedGames(steamAPIKey, id, cache)
-		if err != nil {
-			return nil, fmt.Errorf("fetch user owned games (steamid=%s): %v", id, err)
-		}
-		usersGames[id] = games
+	usersGames, failed, err := fetchSteamUsersOwnedGames(steamAPIKey, users, store, cache)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if err, ok := failed[steamID]; ok {
+		return nil, nil, false, fmt.Errorf("fetch user owned games (steamid=%s): %v", steamID, err)
+	}
+	for id, err := range failed {
+		slog.Warn("fetch user owned games, leaving the user out", "steamid", id, "err", err)
+		failedUsers = append(failedUsers, id)
 	}
 
-	filteredGames := make(map[int]SteamGame, 32) // if you are using this, you have many games
+	sortedUsers := slices.Sorted(maps.Keys(usersGames))
+	minOwners = min(minOwners, len(sortedUsers))
 
-	for appID, game := range usersGames[steamID] {
-		if game.Free {
-			filteredGames[appID] = game
+	libraries := make([][]int, len(sortedUsers))
+	for i, id := range sortedUsers {
+		libraries[i] = sortedLibrary(usersGames[id])
+	}
+
+	// free games owned by anyone, and the rest owned by at least minOwners, even if their
+	// price is unknown
+	for appID, owners := range mergeLibraries(libraries) {
+		free := usersGames[sortedUsers[owners.First()]][appID].PriceStatus == PriceFree
+		if !free && !remotePlay && owners.Count() < minOwners {
 			continue
 		}
+		sortedGames = append(sortedGames, newGroupGame(appID, sortedUsers, owners, usersGames))
+	}
 
-		everyoneHasIt := true
-		for userID, games := range usersGames {
-			if userID == steamID {
-				continue
-			}
-			if _, ok := games[appID]; !ok {
-				everyoneHasIt = false
-				break
-			}
-		}
+	var prices map[int]SteamGamePrice
+	pricesMissing := false
+	if sortMode == SortModeCheapest {
+		prices, pricesMissing = getSortingPrices(sortedGames, cacheKey, cache)
+	}
 
-		if everyoneHasIt {
-			filteredGames[appID] = game
-		}
+	sortGames(sortedGames, sortMode, steamID, prices)
+
+	// the cost already counts the members that don't own it
+	if (minOwners < len(sortedUsers) || remotePlay) && sortMode != SortModeCheapest {
+		slices.SortStableFunc(sortedGames, func(a, b GroupGame) int {
+			return cmp.Compare(len(b.Owners()), len(a.Owners()))
+		})
+	}
+
+	if len(failedUsers) > 0 {
+		slices.Sort(failedUsers)
+		return sortedGames, failedUsers, false, nil
+	}
+	if pricesMissing {
+		return sortedGames, nil, false, nil
 	}
-	for userID, games := range usersGames {
-		if userID == steamID {
+
+	cache.sortedGames.Set(cacheKey, sortedGames)
+
+	return sortedGames, nil, true, nil
+}
+
+// getSortingPrices returns the prices to sort the games by cost, missing is true if some
+// aren't known yet. Only a chunk of the uncached ones is fetched right away, so the first
+// games are listed after a single request. Those are of the games owned by the most
+// members, the cheapest to complete. The rest are fetched in the background, for the next
+// request to sort with.
+func getSortingPrices(games []GroupGame, sortedGamesCacheKey string, cache *CacheGroup) (prices map[int]SteamGamePrice, missing bool) {
+	var appIDs, uncachedAppIDs []int
+	for _, game := range games {
+		if game.Free() || len(game.Owners()) == len(game.Members) {
 			continue
 		}
-		for appID, game := range games {
-			if !game.Free {
-				continue
-			}
-			if _, exists := filteredGames[appID]; exists {
-				continue
-			}
-			filteredGames[appID] = game
+		if _, ok := cache.prices.Get(priceKey{game.AppID, ""}); ok {
+			appIDs = append(appIDs, game.AppID)
+		} else {
+			uncachedAppIDs = append(uncachedAppIDs, game.AppID)
 		}
 	}
 
-	sortedGames := make([]SteamGame, 0, len(filteredGames))
-	for _, game := range filteredGames {
-		sortedGames = append(sortedGames, game)
+	owners := make(map[int]int, len(games))
+	for _, game := range games {
+		owners[game.AppID] = len(game.Owners())
 	}
-	slices.SortFunc(sortedGames, func(a, b SteamGame) int {
-		if a.Playtime2Weeks > 0 || b.Playtime2Weeks > 0 {
-			switch {
-			case a.Playtime2Weeks > b.Playtime2Weeks:
-				return -1
-			case a.Playtime2Weeks < b.Playtime2Weeks:
-				return 1
-			case a.Name > b.Name:
-				return -1
-			}
-			return 1
-		}
-
-		switch {
-		case a.PlaytimeForever > b.PlaytimeForever:
-			return -1
-		case a.PlaytimeForever < b.PlaytimeForever:
-			return 1
-		case a.Name > b.Name:
-			return -1
-		}
-		return 1
+	slices.SortStableFunc(uncachedAppIDs, func(a, b int) int {
+		return cmp.Compare(owners[b], owners[a])
 	})
 
-	cache.sortedGames.Set(cacheKey, sortedGames)
+	now := min(len(uncachedAppIDs), priceChunkSize)
+	appIDs = append(appIDs, uncachedAppIDs[:now]...)
+	later := uncachedAppIDs[now:]
+
+	prices, err := fetchSteamGamesPrices(appIDs, "", cache)
+	if err != nil {
+		slog.Warn("fetch game prices to sort by cost", "count", len(appIDs), "err", err)
+		missing = true
+	}
+
+	if len(later) == 0 {
+		return prices, missing
+	}
+
+	// two requests may start it at the same time, the prices are cached either way
+	if _, ok := cache.pricesPending.Get(sortedGamesCacheKey); !ok {
+		cache.pricesPending.Set(sortedGamesCacheKey, true)
+
+		go func() {
+			defer cache.pricesPending.Delete(sortedGamesCacheKey)
+
+			_, err := fetchSteamGamesPrices(later, "", cache)
+			if err != nil {
+				slog.Warn("fetch game prices to sort by cost in the background", "count", len(later), "err", err)
+			}
+		}()
+	}
 
-	return sortedGames, nil
+	return prices, true
 }
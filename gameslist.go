@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+type GamesListFilter struct {
+	// any online multiplayer game if zero
+	CategoryGroups CategoryGroup
+	ShowFree       bool
+	ShowPaid       bool
+	// paid games owned by fewer than MinOwners members are only listed with Remote Play
+	// Together, the sorted games only have them in that mode
+	RemotePlay bool
+	MinOwners  int
+}
+
+// Match reports whether the game, in these groups, is listed
+func (f GamesListFilter) Match(game GroupGame, groups CategoryGroup) bool {
+	if f.RemotePlay && !game.Free() && len(game.Owners()) < f.MinOwners && groups&CategoryGroupRemotePlay == 0 {
+		return false
+	}
+
+	wanted := f.CategoryGroups
+	if wanted == 0 {
+		wanted = CategoryGroupsOnline
+		if f.RemotePlay {
+			wanted |= CategoryGroupRemotePlay
+		}
+	}
+	return groups&wanted != 0
+}
+
+type GamesListItem struct {
+	Game       GroupGame
+	Categories []int
+}
+
+// GamesList is the filtered list of games for a group and a filter. Candidates are
+// classified in batches only when a page needs them, and every classified game is kept,
+// so any page costs the same as the first one.
+type GamesList struct {
+	filter     GamesListFilter
+	candidates []GroupGame
+	// number of candidates already classified
+	scanned int
+	items   []GamesListItem
+	// appid -> index in items
+	positions map[int]int
+	// built from sorted games that are not cacheable, it only lasts until the next first page
+	provisional bool
+	mu          sync.Mutex
+}
+
+func newGamesList(sortedGames []GroupGame, filter GamesListFilter) *GamesList {
+	candidates := slices.DeleteFunc(slices.Clone(sortedGames), func(game GroupGame) bool {
+		// the ones not known to be free count as paid
+		return game.Free() && !filter.ShowFree || !game.Free() && !filter.ShowPaid
+	})
+
+	return &GamesList{
+		filter:     filter,
+		candidates: candidates,
+		positions:  make(map[int]int),
+	}
+}
+
+func getGamesListCacheKey(sortedGamesCacheKey string, filter GamesListFilter) string {
+	// the sorted games key already has the remote play mode and the min owners
+	return fmt.Sprintf("%s:%d:%t:%t", sortedGamesCacheKey, filter.CategoryGroups, filter.ShowFree, filter.ShowPaid)
+}
+
+// getGamesList is called for first pages, the next ones take the cached list so they
+// follow its order. A provisional list is replaced, so the games are sorted again once
+// the sorted games are cacheable.
+func getGamesList(sortedGames []GroupGame, sortedGamesCacheKey string, cacheable bool, filter GamesListFilter, cache *CacheGroup) *GamesList {
+	cacheKey := getGamesListCacheKey(sortedGamesCacheKey, filter)
+
+	if list, ok := cache.gamesLists.Get(cacheKey); ok && !list.provisional {
+		return list
+	}
+
+	// two requests may build it at the same time, the last one wins
+	list := newGamesList(sortedGames, filter)
+	list.provisional = !cacheable
+	cache.gamesLists.Set(cacheKey, list)
+
+	return list
+}
+
+// scan classifies the next batch of candidates, in order, yielding the ones that are
+// listed as soon as they and the ones before them are classified. A candidate is only
+// consumed once its categories were fetched, so an error can be retried. The candidates
+// whose groups are in the index and don't match are skipped without their categories.
+func (l *GamesList) scan(batchSize int, store Store, cache *CacheGroup, crawler *CategoryCrawler, yield func(GamesListItem)) error {
+	end := min(l.scanned+batchSize, len(l.candidates))
+	batch := l.candidates[l.scanned:end]
+
+	appIDs := make([]int, 0, len(batch))
+	skipped := make(map[int]bool)
+
+	for _, game := range batch {
+		if groups, ok := cache.categoryGroups.Get(game.AppID); ok && !l.filter.Match(game, groups) {
+			skipped[game.AppID] = true
+			continue
+		}
+		appIDs = append(appIDs, game.AppID)
+	}
+
+	categoriesPerGame := make(map[int][]int, len(appIDs))
+
+	advance := func() {
+		for l.scanned < end {
+			game := l.candidates[l.scanned]
+			if skipped[game.AppID] {
+				l.scanned++
+				continue
+			}
+			categories, ok := categoriesPerGame[game.AppID]
+			if !ok {
+				break
+			}
+			l.classify(game, categories, yield)
+			l.scanned++
+		}
+	}
+
+	// the skipped ones at the start don't wait for the rest
+	advance()
+
+	err := fetchGamesCategories(appIDs, CrawlPriorityPage, func(appID int, categories []int) {
+		categoriesPerGame[appID] = categories
+		advance()
+	}, store, cache, crawler)
+	if err != nil {
+		return err
+	}
+
+	// games the steam API didn't answer for are not listed
+	l.scanned = end
+	return nil
+}
+
+func (l *GamesList) classify(game GroupGame, categories []int, yield func(GamesListItem)) {
+	if !l.filter.Match(game, getCategoriesGroups(categories)) {
+		return
+	}
+
+	item := GamesListItem{
+		Game:       game,
+		Categories: categories,
+	}
+	l.positions[game.AppID] = len(l.items)
+	l.items = append(l.items, item)
+
+	yield(item)
+}
+
+// Stream yields up to count items after the cursor, each one as soon as it's classified,
+// and returns the cursor of the next page, which is empty when there are no more items.
+// An empty cursor is the first page. yield is called with the list locked, so it must not block.
+func (l *GamesList) Stream(cursor string, count int, store Store, cache *CacheGroup, crawler *CategoryCrawler, yield func(GamesListItem)) (nextCursor string, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	start := 0
+
+	if len(cursor) > 0 {
+		afterAppID, err := decodeGamesListCursor(cursor)
+		if err != nil {
+			return "", err
+		}
+
+		// only scan up to the cursor, and nothing if it's not a candidate
+		candidateIndex := slices.IndexFunc(l.candidates, func(game GroupGame) bool {
+			return game.AppID == afterAppID
+		})
+		if candidateIndex == -1 {
+			return "", fmt.Errorf("cursor appid %d: %w", afterAppID, ErrItemNotFound)
+		}
+
+		for {
+			if position, ok := l.positions[afterAppID]; ok {
+				start = position + 1
+				break
+			}
+			if l.scanned > candidateIndex {
+				return "", fmt.Errorf("cursor appid %d not listed: %w", afterAppID, ErrItemNotFound)
+			}
+			err := l.scan(count, store, cache, crawler, func(GamesListItem) {})
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+
+	end := min(start+count, len(l.items))
+	for _, item := range l.items[start:end] {
+		yield(item)
+	}
+
+	for end-start < count && l.scanned < len(l.candidates) {
+		err := l.scan(count, store, cache, crawler, func(item GamesListItem) {
+			if end-start < count {
+				end++
+				yield(item)
+			}
+		})
+		// the rest of the batch is tried again with the next page
+		if err != nil && end-start < count {
+			return "", err
+		}
+	}
+
+	if end > start && (end < len(l.items) || l.scanned < len(l.candidates)) {
+		nextCursor = encodeGamesListCursor(l.items[end-1].Game.AppID)
+	}
+
+	return nextCursor, nil
+}
+
+// Upcoming returns up to count games the page after the cursor may list, the listed ones
+// first and then the candidates not classified yet, so what the page needs can be fetched
+// in bulk before streaming it.
+func (l *GamesList) Upcoming(cursor string, count int) []GroupGame {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	games := make([]GroupGame, 0, count)
+
+	if len(cursor) == 0 {
+		for _, item := range l.items[:min(count, len(l.items))] {
+			games = append(games, item.Game)
+		}
+	} else if afterAppID, err := decodeGamesListCursor(cursor); err == nil {
+		if position, ok := l.positions[afterAppID]; ok {
+			for _, item := range l.items[position+1 : min(position+1+count, len(l.items))] {
+				games = append(games, item.Game)
+			}
+		}
+	}
+
+	end := min(l.scanned+count-len(games), len(l.candidates))
+	return append(games, l.candidates[l.scanned:end]...)
+}
+
+// Prefetch fetches the categories of the candidates the next page starts with, behind the
+// pages users are waiting for, so it's usually served from the cache. Nothing is queued if
+// the apps already waiting take the store API requests allowed right now.
+func (l *GamesList) Prefetch(count int, store Store, cache *CacheGroup, crawler *CategoryCrawler) {
+	if backlog, available := crawler.Backlog(), storeAPILimiter.Available(); backlog >= available {
+		slog.Debug("GamesList.Prefetch: store API busy, skipping", "backlog", backlog, "available", available)
+		return
+	}
+
+	l.mu.Lock()
+	end := min(l.scanned+count, len(l.candidates))
+	appIDs := make([]int, 0, end-l.scanned)
+	for _, game := range l.candidates[l.scanned:end] {
+		if groups, ok := cache.categoryGroups.Get(game.AppID); ok && !l.filter.Match(game, groups) {
+			continue
+		}
+		appIDs = append(appIDs, game.AppID)
+	}
+	l.mu.Unlock()
+
+	if len(appIDs) == 0 {
+		return
+	}
+
+	err := fetchGamesCategories(appIDs, CrawlPriorityPrefetch, func(int, []int) {}, store, cache, crawler)
+	if err != nil {
+		slog.Debug("GamesList.Prefetch: fetch games categories", "err", err)
+	}
+}
+
+// The cursor points to the last game of the previous page instead of an offset, so
+// the next page doesn't move when games are added to the list.
+func encodeGamesListCursor(afterAppID int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(afterAppID)))
+}
+
+func decodeGamesListCursor(cursor string) (int, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, fmt.Errorf("%w: decode: %v", ErrInvalidCursor, err)
+	}
+	afterAppID, err := strconv.Atoi(string(decoded))
+	if err != nil {
+		return 0, fmt.Errorf("%w: parse: %v", ErrInvalidCursor, err)
+	}
+	return afterAppID, nil
+}
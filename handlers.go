@@ -1,8 +1,9 @@
 package main
 
 import (
-	"database/sql"
-	"fmt"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"log/slog"
 	"net/http"
 	"net/url"
@@ -10,19 +11,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
-	"what2play/buildflags"
 )
 
-var multiplayerCategories = []int{
-	1,  // Multi-player
-	9,  // Co-op
-	20, // MMO
-	27, // Cross-Platform Multiplayer
-	36, // Online PvP
-	38, // Online Co-op
-	49, // PvP
-}
-
 func handleHealthCheck() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		_ = r.Body.Close()
@@ -123,14 +113,188 @@ func getFavoriteFriendsFromCookie(r *http.Request, steamID string) map[string]st
 	return favoriteFriends
 }
 
-func handleGames(steamAPIKey string, db *sql.DB, cache *CacheGroup) http.Handler {
+// GamesQuery is the query params shared by /games and /games/stream
+type GamesQuery struct {
+	Friends []string
+	// opaque, the first page has none
+	Cursor   string
+	SortMode SortMode
+	// multiplayer games in any of these groups are listed, or every multiplayer game if none
+	CategoryFilter     CategoryGroup
+	CategoryFilterSent bool
+	ShowFree           bool
+	ShowPaid           bool
+	// paid games owned by at least this number of members are listed
+	MinOwners int
+	// also list the Remote Play Together games owned by fewer members
+	RemotePlay bool
+	// the prices of the user are from this country, or the one of their profile if empty
+	CountryCode     string
+	CountryCodeSent bool
+}
+
+func (q GamesQuery) Users(steamID string) []string {
+	return append(slices.Clone(q.Friends), steamID)
+}
+
+// parseGamesQuery returns the error to show the user if the query is not valid
+func parseGamesQuery(r *http.Request, steamID string, store Store) (q GamesQuery, err error) {
+	query := r.URL.Query()
+
+	var ok bool
+	q.Friends, ok = query["steamid"]
+	if !ok {
+		return q, errors.New("missing steamid query param")
+	}
+	for _, id := range q.Friends {
+		if len(id) == 0 {
+			return q, errors.New("invalid steamid query param")
+		}
+	}
+	if len(q.Friends)+1 > maxGroupSize {
+		return q, errors.New("too many steamid query params")
+	}
+
+	q.Cursor = query.Get("cursor")
+	if len(q.Cursor) > 0 {
+		_, err := decodeGamesListCursor(q.Cursor)
+		if err != nil {
+			return q, errors.New("invalid cursor query param")
+		}
+	}
+
+	q.SortMode, ok = parseSortMode(query.Get("sort"))
+	if !ok {
+		return q, errors.New("invalid sort query param")
+	}
+
+	// the choice is remembered when the param is sent, and used when it's not
+	var categoryFilterNames []string
+	categoryFilterNames, q.CategoryFilterSent = query["category"]
+	if !q.CategoryFilterSent {
+		savedFilter, ok, err := store.Preference(steamID, PreferenceCategoryFilter)
+		if err != nil {
+			slog.Error("get category filter preference", "steamid", steamID, "err", err)
+		}
+		if ok {
+			categoryFilterNames = strings.Split(savedFilter, ",")
+		}
+	}
+
+	q.CategoryFilter, ok = parseCategoryGroupFilter(categoryFilterNames)
+	if !ok {
+		return q, errors.New("invalid category query param")
+	}
+
+	// remembered like the category filter
+	if _, q.CountryCodeSent = query["cc"]; q.CountryCodeSent {
+		q.CountryCode = strings.ToUpper(query.Get("cc"))
+	} else {
+		savedCountryCode, ok, err := store.Preference(steamID, PreferenceCountryCode)
+		if err != nil {
+			slog.Error("get country code preference", "steamid", steamID, "err", err)
+		}
+		if ok {
+			q.CountryCode = savedCountryCode
+		}
+	}
+	if len(q.CountryCode) > 0 && !isCountryCode(q.CountryCode) {
+		return q, errors.New("invalid cc query param")
+	}
+
+	q.ShowFree, q.ShowPaid = true, true
+	if prices, ok := query["price"]; ok {
+		q.ShowFree = slices.Contains(prices, "free")
+		q.ShowPaid = slices.Contains(prices, "paid")
+	}
+
+	switch query.Get("remote_play") {
+	case "", "0":
+	case "1":
+		q.RemotePlay = true
+	default:
+		return q, errors.New("invalid remote_play query param")
+	}
+
+	usersCount := len(q.Friends) + 1
+
+	// "missing" is the number of members allowed to not own it
+	q.MinOwners = usersCount
+	if minOwnersStr := query.Get("min_owners"); len(minOwnersStr) > 0 {
+		q.MinOwners, err = strconv.Atoi(minOwnersStr)
+		if err != nil || q.MinOwners < 1 || q.MinOwners > usersCount {
+			return q, errors.New("invalid min_owners query param")
+		}
+	} else if maxMissingStr := query.Get("missing"); len(maxMissingStr) > 0 {
+		maxMissing, err := strconv.Atoi(maxMissingStr)
+		if err != nil || maxMissing < 0 || maxMissing >= usersCount {
+			return q, errors.New("invalid missing query param")
+		}
+		q.MinOwners = usersCount - maxMissing
+	}
+
+	return q, nil
+}
+
+// getGamesURL returns the url of path with the query q, and the given cursor
+func getGamesURL(path string, q GamesQuery, cursor string) string {
+	queryParams := url.Values{}
+	queryParams["steamid"] = q.Friends
+	if len(cursor) > 0 {
+		queryParams.Set("cursor", cursor)
+	}
+	queryParams.Set("sort", string(q.SortMode))
+	// keep the filter even if it came from the preferences
+	queryParams["category"] = append([]string{""}, formatCategoryGroupFilter(q.CategoryFilter)...)
+	if !q.ShowFree || !q.ShowPaid {
+		queryParams.Add("price", "")
+		if q.ShowFree {
+			queryParams.Add("price", "free")
+		}
+		if q.ShowPaid {
+			queryParams.Add("price", "paid")
+		}
+	}
+	queryParams.Set("min_owners", strconv.Itoa(q.MinOwners))
+	queryParams.Set("cc", q.CountryCode)
+	if q.RemotePlay {
+		queryParams.Set("remote_play", "1")
+	}
+
+	gamesURL := url.URL{
+		Path:     path,
+		RawQuery: queryParams.Encode(),
+	}
+	return gamesURL.String()
+}
+
+func handleGames(steamAPIKey string, store Store, cache *CacheGroup) http.Handler {
 	templs := getTemplates("base.tmpl", "header.tmpl", "games.tmpl")
 
+	type SortOption struct {
+		Mode     SortMode
+		Label    string
+		Selected bool
+	}
+
+	type CategoryFilterOption struct {
+		Name    string
+		Label   string
+		Checked bool
+	}
+
 	type Data struct {
-		User        SteamUserInfo
-		Games       []SteamGame
-		NextPageURL string
-		DevMode     bool
+		User           SteamUserInfo
+		StreamURL      string
+		Friends        []string
+		SortOptions    []SortOption
+		CategoryFilter []CategoryFilterOption
+		ShowFree       bool
+		ShowPaid       bool
+		RemotePlay     bool
+		MinOwners      int
+		UsersCount     int
+		CountryCode    string
 	}
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -139,113 +303,344 @@ func handleGames(steamAPIKey string, db *sql.DB, cache *CacheGroup) http.Handler
 		}()
 
 		steamID := r.Context().Value(steamIDKey).(string)
-		_ = steamID
 
-		friends, ok := r.URL.Query()["steamid"]
-		if !ok {
-			blameUser(w, "missing steamid query param")
+		q, err := parseGamesQuery(r, steamID, store)
+		if err != nil {
+			blameUser(w, err.Error())
 			return
 		}
-		for _, id := range friends {
-			if len(id) == 0 {
-				blameUser(w, "invalid steamid query param")
+
+		// the games are classified by /games/stream, so pages are sent right away
+		streamURL := getGamesURL("/games/stream", q, q.Cursor)
+
+		if len(q.Cursor) > 0 {
+			err := renderTemplate(w, templs.Lookup("games-stream"), http.StatusOK, streamURL)
+			if err != nil {
+				slog.Error("send games-stream template", "err", err)
 				return
 			}
+			return
+		}
+
+		if q.CategoryFilterSent {
+			err := store.SavePreference(steamID, PreferenceCategoryFilter, strings.Join(formatCategoryGroupFilter(q.CategoryFilter), ","))
+			if err != nil {
+				slog.Error("save category filter preference", "steamid", steamID, "err", err)
+			}
+		}
+
+		if q.CountryCodeSent {
+			err := store.SavePreference(steamID, PreferenceCountryCode, q.CountryCode)
+			if err != nil {
+				slog.Error("save country code preference", "steamid", steamID, "err", err)
+			}
 		}
 
-		pageStr := r.URL.Query().Get("page")
-		if len(pageStr) == 0 {
-			blameUser(w, "invalid page query param")
+		usersInfo, err := fetchSteamUsersInfo(steamAPIKey, []string{steamID}, cache)
+		if err != nil || len(usersInfo) == 0 {
+			slog.Error("fetch user info", "steamid", steamID, "err", err)
+			blameValve(w)
 			return
 		}
-		page, err := strconv.ParseInt(pageStr, 10, 16)
+
+		data := Data{
+			User:        usersInfo[0],
+			StreamURL:   streamURL,
+			Friends:     q.Friends,
+			ShowFree:    q.ShowFree,
+			ShowPaid:    q.ShowPaid,
+			RemotePlay:  q.RemotePlay,
+			MinOwners:   q.MinOwners,
+			UsersCount:  len(q.Friends) + 1,
+			CountryCode: q.CountryCode,
+		}
+		for _, f := range categoryGroupFilters {
+			data.CategoryFilter = append(data.CategoryFilter, CategoryFilterOption{
+				Name:    f.Name,
+				Label:   f.Label,
+				Checked: q.CategoryFilter&f.Group != 0,
+			})
+		}
+		for _, m := range sortModes {
+			data.SortOptions = append(data.SortOptions, SortOption{
+				Mode:     m.Mode,
+				Label:    m.Label,
+				Selected: m.Mode == q.SortMode,
+			})
+		}
+
+		err = renderTemplate(w, templs.Lookup("games.tmpl"), http.StatusOK, data)
 		if err != nil {
-			blameUser(w, "invalid page query param")
+			slog.Error("send games.tmpl template", "err", err)
 			return
 		}
+	})
+}
 
-		_usersInfo, err := fetchSteamUsersInfo(steamAPIKey, []string{steamID}, cache)
-		if err != nil || len(_usersInfo) == 0 {
-			slog.Error("fetch user info", "steamid", steamID, "err", err)
-			blameValve(w)
+// handleGamesStream sends the games of a page as server-sent events, a "game" event with
+// each card as soon as it's classified, and a "done" event with the end of the page.
+// Errors are sent as a "redirect" event to the error page.
+// If the client leaves, the rest of the page is still classified so it's cached.
+func handleGamesStream(steamAPIKey string, store Store, cache *CacheGroup, crawler *CategoryCrawler) http.Handler {
+	templs := getTemplates("games.tmpl")
+
+	type Playtime struct {
+		Username string
+		Owned    bool
+		Hours    int
+	}
+
+	type MissingUser struct {
+		Username string
+		// in the currency of the member
+		Price SteamGamePrice
+	}
+
+	type Game struct {
+		GroupGame
+		OwnersCount int
+		Badges      []Category
+		Playtimes   []Playtime
+		// members that don't own it
+		MissingUsers []MissingUser
+		// what all of them would spend, in each of their currencies
+		GroupCost []string
+		// username of the owner the others can join with Remote Play Together, when
+		// not everyone owns it
+		Host string
+	}
+
+	type NoticeData struct {
+		// members whose games couldn't be fetched
+		Usernames []string
+	}
+
+	type EndData struct {
+		NextPageURL string
+		// the first page has no games
+		Empty bool
+		// whether any filter that can hide games is set
+		Filtered bool
+	}
+
+	const gamesPerPage = 20
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			_ = r.Body.Close()
+		}()
+
+		steamID := r.Context().Value(steamIDKey).(string)
+
+		// an EventSource ignores HX-Redirect, once the stream is open every error is an event
+		events := newEventStream(w)
+
+		q, err := parseGamesQuery(r, steamID, store)
+		if err != nil {
+			_ = events.Send("redirect", "/server-error/user-fault?msg="+url.QueryEscape(err.Error()))
 			return
 		}
-		userInfo := _usersInfo[0]
 
-		users := append(friends, steamID)
+		users := q.Users(steamID)
 
-		sortedGames, err := getSteamSortedGames(steamAPIKey, steamID, users, cache)
+		usersInfo, err := fetchSteamUsersInfo(steamAPIKey, users, cache)
 		if err != nil {
-			slog.Error("get sorted games", "steamids", users, "err", err)
-			blameValve(w)
+			slog.Error("fetch users info", "steamids", users, "err", err)
+			_ = events.Send("redirect", "/server-error/valve-fault")
 			return
 		}
 
-		const gamesPerPage = 20
+		usernames := make(map[string]string, len(usersInfo))
+		countryCodes := make(map[string]string, len(usersInfo))
+		for _, info := range usersInfo {
+			usernames[info.SteamID] = info.Username
+			// it comes from the steam API, the prices of the server's region are used
+			// if it's not one
+			if isCountryCode(info.CountryCode) {
+				countryCodes[info.SteamID] = info.CountryCode
+			}
+		}
+
+		// each member sees the prices of the country they chose, or of their profile
+		if len(q.CountryCode) > 0 {
+			countryCodes[steamID] = q.CountryCode
+		}
+		for _, id := range q.Friends {
+			countryCode, ok, err := store.Preference(id, PreferenceCountryCode)
+			if err != nil {
+				slog.Error("get country code preference", "steamid", id, "err", err)
+			}
+			if ok && len(countryCode) > 0 {
+				countryCodes[id] = countryCode
+			}
+		}
 
-		finalGames := make([]SteamGame, 0, gamesPerPage)
-		skipped := 0
-		offset := gamesPerPage * int(page)
+		filter := GamesListFilter{
+			CategoryGroups: q.CategoryFilter,
+			ShowFree:       q.ShowFree,
+			ShowPaid:       q.ShowPaid,
+			RemotePlay:     q.RemotePlay,
+			MinOwners:      q.MinOwners,
+		}
 
-		categoriesIter := newFetchGameCategoriesIter(sortedGames, gamesPerPage, db, cache)
+		// the next pages keep the order of the first one, even if it was sorted with
+		// missing prices
+		var gamesList *GamesList
+		if len(q.Cursor) > 0 {
+			sortedGamesCacheKey := getSortedGamesCacheKey(steamID, users, q.SortMode, q.MinOwners, q.RemotePlay)
+			gamesList, _ = cache.gamesLists.Get(getGamesListCacheKey(sortedGamesCacheKey, filter))
+		}
 
-		for gameAndCategories, err := range categoriesIter {
+		if gamesList == nil {
+			sortedGames, failedUsers, cacheable, err := getSteamSortedGames(steamAPIKey, steamID, users, q.SortMode, q.MinOwners, q.RemotePlay, store, cache)
 			if err != nil {
-				slog.Error("fetch game categories", "err", err)
-				blameValve(w)
+				slog.Error("get sorted games", "steamids", users, "err", err)
+				_ = events.Send("redirect", "/server-error/valve-fault")
 				return
 			}
 
-			if len(finalGames) == gamesPerPage {
-				break
+			if len(failedUsers) > 0 {
+				// the next pages are of the group without them, so they match this one
+				q.Friends = slices.DeleteFunc(slices.Clone(q.Friends), func(id string) bool {
+					return slices.Contains(failedUsers, id)
+				})
+				q.MinOwners = min(q.MinOwners, len(q.Friends)+1)
+				filter.MinOwners = q.MinOwners
+				users = q.Users(steamID)
+
+				notice := NoticeData{}
+				for _, id := range failedUsers {
+					notice.Usernames = append(notice.Usernames, usernames[id])
+				}
+				err := events.SendTemplate("notice", templs.Lookup("games-notice"), notice)
+				if err != nil {
+					slog.Debug("send notice event", "err", err)
+					return
+				}
 			}
 
-			isMultiplayer := false
-			for _, category := range gameAndCategories.categories {
-				if slices.Contains(multiplayerCategories, category) {
-					isMultiplayer = true
-					break
+			gamesList = getGamesList(sortedGames, getSortedGamesCacheKey(steamID, users, q.SortMode, q.MinOwners, q.RemotePlay), cacheable, filter, cache)
+		}
+
+		// the prices of the games the page likely starts with are fetched up front, in one
+		// request for each country instead of one for each card
+		_ = fetchMissingGamesPrices(gamesList.Upcoming(q.Cursor, gamesPerPage), countryCodes, cache)
+
+		// buffered for the whole page, so the classification doesn't wait for the client
+		items := make(chan GamesListItem, gamesPerPage)
+		var nextCursor string
+		var pageErr error
+
+		go func() {
+			defer close(items)
+			nextCursor, pageErr = gamesList.Stream(q.Cursor, gamesPerPage, store, cache, crawler, func(item GamesListItem) {
+				items <- item
+			})
+		}()
+
+		gamesCount := 0
+
+		for item := range items {
+			// the games classified meanwhile are sent with it, so the prices that weren't
+			// fetched up front take one request for each country
+			batch := []GamesListItem{item}
+		receive:
+			for {
+				select {
+				case item, ok := <-items:
+					if !ok {
+						break receive
+					}
+					batch = append(batch, item)
+				default:
+					break receive
 				}
 			}
 
-			if !isMultiplayer {
-				continue
+			batchGames := make([]GroupGame, len(batch))
+			for i, item := range batch {
+				batchGames[i] = item.Game
 			}
+			// the cards go without a price if it can't be fetched
+			pricesByCountry := fetchMissingGamesPrices(batchGames, countryCodes, cache)
+
+			for _, item := range batch {
+				game := Game{
+					GroupGame:   item.Game,
+					OwnersCount: len(item.Game.Owners()),
+					Badges:      getMultiplayerBadges(item.Categories, cache),
+				}
+				for _, member := range game.Members {
+					game.Playtimes = append(game.Playtimes, Playtime{
+						Username: usernames[member.SteamID],
+						Owned:    member.Owned,
+						Hours:    member.PlaytimeForever / 60,
+					})
+					if member.Owned || game.Free() {
+						continue
+					}
 
-			if skipped < offset {
-				skipped++
-				continue
-			}
-			finalGames = append(finalGames, gameAndCategories.game)
-		}
+					game.MissingUsers = append(game.MissingUsers, MissingUser{
+						Username: usernames[member.SteamID],
+						Price:    pricesByCountry[countryCodes[member.SteamID]][game.AppID],
+					})
+				}
 
-		data := Data{
-			User:    userInfo,
-			Games:   finalGames,
-			DevMode: buildflags.Dev,
-		}
-		if len(finalGames) > 0 {
-			queryParams := r.URL.Query()
-			queryParams.Set("page", fmt.Sprint(page+1))
+				if q.RemotePlay && len(game.MissingUsers) > 0 && slices.Contains(item.Categories, categoryRemotePlayTogether) {
+					host, _ := item.Game.Host()
+					game.Host = usernames[host.SteamID]
+				}
 
-			nextPageURL := *r.URL
-			nextPageURL.RawQuery = queryParams.Encode()
+				// left out if any price is not known, the total would be too low
+				complete := !slices.ContainsFunc(game.MissingUsers, func(user MissingUser) bool {
+					return user.Price.Status != PricePaid
+				})
+				if len(game.MissingUsers) > 1 && complete {
+					var currencies []string
+					totals := make(map[string]int)
+					for _, user := range game.MissingUsers {
+						if _, ok := totals[user.Price.Currency]; !ok {
+							currencies = append(currencies, user.Price.Currency)
+						}
+						totals[user.Price.Currency] += user.Price.Final
+					}
+					for _, currency := range currencies {
+						game.GroupCost = append(game.GroupCost, formatPrice(totals[currency], currency))
+					}
+				}
 
-			data.NextPageURL = nextPageURL.String()
+				err := events.SendTemplate("game", templs.Lookup("game-card"), game)
+				if err != nil {
+					slog.Debug("send game event", "err", err)
+					return
+				}
+				gamesCount++
+			}
 		}
 
-		if page == 0 {
-			err = renderTemplate(w, templs.Lookup("games.tmpl"), http.StatusOK, data)
-			if err != nil {
-				slog.Error("send games.tmpl template", "err", err)
+		if pageErr != nil {
+			if errors.Is(pageErr, ErrItemNotFound) {
+				_ = events.Send("redirect", "/server-error/user-fault?msg="+url.QueryEscape("invalid cursor query param"))
 				return
 			}
+			slog.Error("get games page", "err", pageErr)
+			_ = events.Send("redirect", "/server-error/valve-fault")
 			return
 		}
 
-		err = renderTemplate(w, templs.Lookup("games-page"), http.StatusOK, data)
+		endData := EndData{
+			Empty:    len(q.Cursor) == 0 && gamesCount == 0,
+			Filtered: q.CategoryFilter != 0 || !q.ShowFree || !q.ShowPaid,
+		}
+		if len(nextCursor) > 0 {
+			endData.NextPageURL = getGamesURL("/games", q, nextCursor)
+
+			go gamesList.Prefetch(gamesPerPage, store, cache, crawler)
+		}
+
+		err = events.SendTemplate("done", templs.Lookup("games-end"), endData)
 		if err != nil {
-			slog.Error("send games-page template", "err", err)
+			slog.Debug("send done event", "err", err)
 			return
 		}
 	})
@@ -398,7 +793,39 @@ func handleLogin(steamAPIKey string, cache *CacheGroup) http.Handler {
 	})
 }
 
-func handleLoginConfirm() http.Handler {
+const sessionMaxAge = time.Hour * 24 * 365
+
+// startSession saves a new session for the user, and sets its cookie
+func startSession(w http.ResponseWriter, steamID string, store Store, cache *CacheGroup) (Session, error) {
+	token := make([]byte, 32)
+	_, err := rand.Read(token)
+	assert(err == nil, err)
+
+	session := Session{
+		Token:     hex.EncodeToString(token),
+		SteamID:   steamID,
+		ExpiresAt: time.Now().Add(sessionMaxAge),
+	}
+	err = store.SaveSession(session)
+	if err != nil {
+		return Session{}, err
+	}
+	cache.sessions.Set(session.Token, session)
+
+	cookie := http.Cookie{
+		Name:     CookieSession,
+		Value:    session.Token,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+		Path:     "/",
+		MaxAge:   int(sessionMaxAge.Seconds()),
+	}
+	http.SetCookie(w, &cookie)
+
+	return session, nil
+}
+
+func handleLoginConfirm(store Store, cache *CacheGroup) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			_ = r.Body.Close()
@@ -411,29 +838,42 @@ func handleLoginConfirm() http.Handler {
 			return
 		}
 
-		cookie := http.Cookie{
-			Name:     CookieSteamID,
-			Value:    steamID,
-			HttpOnly: true,
-			SameSite: http.SameSiteStrictMode,
-			Path:     "/",
-			MaxAge:   int((time.Hour * 24 * 365).Seconds()),
+		_, err := startSession(w, steamID, store, cache)
+		if err != nil {
+			slog.Error("save session", "steamid", steamID, "err", err)
+			blameMyself(w)
+			return
 		}
-		http.SetCookie(w, &cookie)
 
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 	})
 }
 
-func handleLogout() http.Handler {
+func handleLogout(store Store, cache *CacheGroup) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			_ = r.Body.Close()
 		}()
 		assert(r.Method == http.MethodGet, r.Method)
 
+		sessionCookie, err := getCookie(r, CookieSession)
+		if err != nil {
+			slog.Error("get session cookie", "err", err)
+			blameMyself(w)
+			return
+		}
+		if sessionCookie != nil {
+			cache.sessions.Delete(sessionCookie.Value)
+			err = store.DeleteSession(sessionCookie.Value)
+			if err != nil {
+				slog.Error("delete session", "err", err)
+				blameMyself(w)
+				return
+			}
+		}
+
 		cookie := http.Cookie{
-			Name:     CookieSteamID,
+			Name:     CookieSession,
 			SameSite: http.SameSiteStrictMode,
 			MaxAge:   -1,
 		}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"iter"
+	"math/bits"
+	"slices"
+)
+
+// OwnersMask has the bit i set when the member i owns the game, so a group has up to 64 members
+type OwnersMask uint64
+
+const maxGroupSize = 64
+
+func (m OwnersMask) Count() int {
+	return bits.OnesCount64(uint64(m))
+}
+
+func (m OwnersMask) Has(member int) bool {
+	return m&(1<<member) != 0
+}
+
+// First returns the first member that owns the game, or -1 if nobody does
+func (m OwnersMask) First() int {
+	if m == 0 {
+		return -1
+	}
+	return bits.TrailingZeros64(uint64(m))
+}
+
+// sortedLibrary returns the appids of the library in ascending order
+func sortedLibrary(games map[int]SteamGame) []int {
+	appIDs := make([]int, 0, len(games))
+	for appID := range games {
+		appIDs = append(appIDs, appID)
+	}
+	slices.Sort(appIDs)
+	return appIDs
+}
+
+// mergeLibraries walks the sorted appids of every library at once, yielding each appid in
+// ascending order with the members that own it. Any intersection can be taken in the same
+// pass by the owners count: all of them, k of n, or any of them.
+func mergeLibraries(libraries [][]int) iter.Seq2[int, OwnersMask] {
+	assert(len(libraries) <= maxGroupSize, len(libraries))
+
+	return func(yield func(int, OwnersMask) bool) {
+		positions := make([]int, len(libraries))
+
+		for {
+			// with groups this small, a linear scan beats a heap
+			appID, found := 0, false
+			for i, library := range libraries {
+				if positions[i] == len(library) {
+					continue
+				}
+				if current := library[positions[i]]; !found || current < appID {
+					appID, found = current, true
+				}
+			}
+			if !found {
+				return
+			}
+
+			var owners OwnersMask
+			for i, library := range libraries {
+				if positions[i] < len(library) && library[positions[i]] == appID {
+					owners |= 1 << i
+					positions[i]++
+				}
+			}
+
+			if !yield(appID, owners) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+func TestMergeLibraries(t *testing.T) {
+	libraries := [][]int{
+		{10, 20, 30, 40},
+		{20, 30, 50},
+		{5, 30, 40, 50},
+	}
+
+	want := map[int]OwnersMask{
+		5:  0b100,
+		10: 0b001,
+		20: 0b011,
+		30: 0b111,
+		40: 0b101,
+		50: 0b110,
+	}
+
+	var appIDs []int
+	got := make(map[int]OwnersMask)
+	for appID, owners := range mergeLibraries(libraries) {
+		appIDs = append(appIDs, appID)
+		got[appID] = owners
+	}
+
+	if !slices.IsSorted(appIDs) || len(appIDs) != len(got) {
+		t.Fatalf("appids %v are not ascending and unique", appIDs)
+	}
+	if !maps.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	// every intersection is taken by the owners count
+	for _, tt := range []struct {
+		name      string
+		minOwners int
+		want      []int
+	}{
+		{"all of", 3, []int{30}},
+		{"2 of 3", 2, []int{20, 30, 40, 50}},
+		{"any of", 1, []int{5, 10, 20, 30, 40, 50}},
+	} {
+		var intersection []int
+		for appID, owners := range mergeLibraries(libraries) {
+			if owners.Count() >= tt.minOwners {
+				intersection = append(intersection, appID)
+			}
+		}
+		if !slices.Equal(intersection, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, intersection, tt.want)
+		}
+	}
+}
+
+func TestMergeLibrariesEmpty(t *testing.T) {
+	for appID, owners := range mergeLibraries([][]int{{}, nil}) {
+		t.Fatalf("yielded %d with owners %b", appID, owners)
+	}
+}
+
+func TestOwnersMask(t *testing.T) {
+	owners := OwnersMask(0b10100)
+	if owners.Count() != 2 || owners.First() != 2 || !owners.Has(4) || owners.Has(3) {
+		t.Fatalf("unexpected %b: count %d, first %d", owners, owners.Count(), owners.First())
+	}
+	if OwnersMask(0).First() != -1 {
+		t.Fatal("an empty mask has a first owner")
+	}
+}
+
+// benchLibraries are the libraries of a group of 10, each with 3000 of 6000 games
+func benchLibraries() []map[int]SteamGame {
+	const members = 10
+	const librarySize = 3000
+
+	random := rand.New(rand.NewPCG(1, 2))
+	libraries := make([]map[int]SteamGame, members)
+	for i := range libraries {
+		libraries[i] = make(map[int]SteamGame, librarySize)
+		for _, appID := range random.Perm(2 * librarySize)[:librarySize] {
+			libraries[i][appID] = SteamGame{AppID: appID}
+		}
+	}
+	return libraries
+}
+
+func BenchmarkMergeLibraries(b *testing.B) {
+	libraries := benchLibraries()
+	minOwners := len(libraries) / 2
+	b.ResetTimer()
+
+	for range b.N {
+		sorted := make([][]int, len(libraries))
+		for i, library := range libraries {
+			sorted[i] = sortedLibrary(library)
+		}
+
+		var appIDs []int
+		for appID, owners := range mergeLibraries(sorted) {
+			if owners.Count() >= minOwners {
+				appIDs = append(appIDs, appID)
+			}
+		}
+	}
+}
+
+// BenchmarkProbeLibraries is the baseline, probing every library for each game of each one
+func BenchmarkProbeLibraries(b *testing.B) {
+	libraries := benchLibraries()
+	minOwners := len(libraries) / 2
+	b.ResetTimer()
+
+	for range b.N {
+		filtered := make(map[int][]bool, 32)
+		for _, library := range libraries {
+			for appID := range library {
+				if _, ok := filtered[appID]; ok {
+					continue
+				}
+
+				owned := make([]bool, len(libraries))
+				count := 0
+				for i, other := range libraries {
+					if _, ok := other[appID]; ok {
+						owned[i] = true
+						count++
+					}
+				}
+				if count >= minOwners {
+					filtered[appID] = owned
+				}
+			}
+		}
+	}
+}
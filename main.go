@@ -3,7 +3,6 @@ package main
 import (
 	"bytes"
 	"context"
-	"database/sql"
 	"embed"
 	"errors"
 	"fmt"
@@ -14,6 +13,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -104,7 +104,10 @@ func httpGet(reqURL string) (resp *http.Response, err error) {
 	return http.Get(reqURL)
 }
 
-const CookieSteamID = "steamid"
+const CookieSession = "session"
+
+// The cookie from before sessions, it's swapped for a session the next time it's sent
+const CookieLegacySteamID = "steamid"
 
 func getCookie(r *http.Request, name string) (*http.Cookie, error) {
 	cookie, err := r.Cookie(name)
@@ -135,28 +138,110 @@ func renderTemplate(w http.ResponseWriter, templ *template.Template, status int,
 	return nil
 }
 
+// eventStream sends server-sent events, each one is flushed right away
+type eventStream struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+func newEventStream(w http.ResponseWriter) *eventStream {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	return &eventStream{
+		w:  w,
+		rc: http.NewResponseController(w),
+	}
+}
+
+func (s *eventStream) Send(event, data string) error {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "event: %s\n", event)
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(buf, "data: %s\n", line)
+	}
+	buf.WriteString("\n")
+
+	_, err := buf.WriteTo(s.w)
+	if err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+func (s *eventStream) SendTemplate(event string, templ *template.Template, data any) error {
+	buf := new(bytes.Buffer)
+	err := templ.Execute(buf, data)
+	assert(err == nil, err)
+
+	return s.Send(event, buf.String())
+}
+
 type Middleware = func(http.Handler) http.Handler
 
 type contextKey byte
 
 const steamIDKey contextKey = iota
 
-func newSteamIDMiddleware() Middleware {
+// newSteamIDMiddleware gets the session from the cache, or from the store the first time
+// it's seen since the start
+func newSteamIDMiddleware(store Store, cache *CacheGroup) Middleware {
 	return func(handler http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			steamIDCookie, err := getCookie(r, "steamid")
+			sessionCookie, err := getCookie(r, CookieSession)
 			if err != nil {
-				slog.Error("get steamid cookie", "err", err)
+				slog.Error("get session cookie", "err", err)
 				blameMyself(w)
 				return
 			}
 
-			if steamIDCookie == nil {
+			if sessionCookie == nil {
+				legacyCookie, err := getCookie(r, CookieLegacySteamID)
+				if err != nil {
+					slog.Error("get legacy steamid cookie", "err", err)
+					blameMyself(w)
+					return
+				}
+				if legacyCookie == nil || len(legacyCookie.Value) == 0 {
+					http.Redirect(w, r, "/login", http.StatusSeeOther)
+					return
+				}
+
+				// so the users logged in before sessions stay logged in
+				session, err := startSession(w, legacyCookie.Value, store, cache)
+				if err != nil {
+					slog.Error("start session from legacy cookie", "steamid", legacyCookie.Value, "err", err)
+					blameMyself(w)
+					return
+				}
+				http.SetCookie(w, &http.Cookie{
+					Name:   CookieLegacySteamID,
+					Path:   "/",
+					MaxAge: -1,
+				})
+				sessionCookie = &http.Cookie{Value: session.Token}
+			}
+
+			session, ok := cache.sessions.Get(sessionCookie.Value)
+			if !ok {
+				session, ok, err = store.Session(sessionCookie.Value)
+				if err != nil {
+					slog.Error("get session", "err", err)
+					blameMyself(w)
+					return
+				}
+				if ok {
+					cache.sessions.Set(session.Token, session)
+				}
+			}
+
+			if !ok || time.Now().After(session.ExpiresAt) {
 				http.Redirect(w, r, "/login", http.StatusSeeOther)
 				return
 			}
 
-			ctx := context.WithValue(r.Context(), steamIDKey, steamIDCookie.Value)
+			ctx := context.WithValue(r.Context(), steamIDKey, session.SteamID)
 			r = r.WithContext(ctx)
 
 			handler.ServeHTTP(w, r)
@@ -175,6 +260,12 @@ func newThrottleMiddleware(requestsPerDay int) Middleware {
 
 			if requestsCountPerUser[ip]+1 > requestsPerDay {
 				_ = r.Body.Close()
+				// an EventSource ignores HX-Redirect and the body
+				if r.Header.Get("Accept") == "text/event-stream" {
+					msg := "the limit for the number of requests per day has been reached"
+					_ = newEventStream(w).Send("redirect", "/server-error/user-fault?msg="+url.QueryEscape(msg))
+					return
+				}
 				// TODO
 				w.WriteHeader(http.StatusTooManyRequests)
 				w.Write([]byte("The limit for the number of requests per day has been reached\n"))
@@ -232,31 +323,53 @@ func (c *Cache[K, E]) Set(key K, value E) {
 	c.cache[key] = value
 }
 
+func (c *Cache[K, E]) Delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.cache, key)
+}
+
 type CacheGroup struct {
 	games          Cache[string, map[int]SteamGame]
-	prices         Cache[int, SteamGamePrice]
+	prices         Cache[priceKey, SteamGamePrice]
+	priceRetries   Cache[priceKey, time.Time] // when a price missing from a response is requested again
 	usersInfo      Cache[string, SteamUserInfo]
 	friends        Cache[string, []string]
 	steamIDs       Cache[string, string]
-	sortedGames    Cache[string, []SteamGame]
+	sortedGames    Cache[string, []GroupGame]
+	gamesLists     Cache[string, *GamesList]
 	gameCategories Cache[int, []int]
+	// appid -> groups of its categories, for every game whose categories are known
+	categoryGroups Cache[int, CategoryGroup]
+	categoryNames  Cache[int, string]
+	// token -> session, so only the first request of a session since the start reads the store
+	sessions Cache[string, Session]
+	// sorted games key -> true while the rest of the prices to sort them by cost are fetched
+	pricesPending Cache[string, bool]
 }
 
 func newCacheGroup() *CacheGroup {
 	return &CacheGroup{
 		games:          newCache[string, map[int]SteamGame](),
-		prices:         newCache[int, SteamGamePrice](),
+		prices:         newCache[priceKey, SteamGamePrice](),
+		priceRetries:   newCache[priceKey, time.Time](),
 		usersInfo:      newCache[string, SteamUserInfo](),
 		friends:        newCache[string, []string](),
 		steamIDs:       newCache[string, string](),
-		sortedGames:    newCache[string, []SteamGame](),
+		sortedGames:    newCache[string, []GroupGame](),
+		gamesLists:     newCache[string, *GamesList](),
 		gameCategories: newCache[int, []int](),
+		categoryGroups: newCache[int, CategoryGroup](),
+		categoryNames:  newCache[int, string](),
+		sessions:       newCache[string, Session](),
+		pricesPending:  newCache[string, bool](),
 	}
 }
 
-func getRoutes(steamAPIKey string, db *sql.DB, cache *CacheGroup) (http.Handler, error) {
+func getRoutes(steamAPIKey string, store Store, cache *CacheGroup, crawler *CategoryCrawler) (http.Handler, error) {
 	throttleMid := newThrottleMiddleware(120)
-	steamIDMid := newSteamIDMiddleware()
+	steamIDMid := newSteamIDMiddleware(store, cache)
 	latencyMid := newLatencyMiddleware(500 * time.Millisecond)
 
 	mux := http.NewServeMux()
@@ -272,10 +385,13 @@ func getRoutes(steamAPIKey string, db *sql.DB, cache *CacheGroup) (http.Handler,
 	loginHandler := chainMiddlewares(handleLogin(steamAPIKey, cache), throttleMid)
 	mux.Handle("GET /login", loginHandler)
 	mux.Handle("POST /login", loginHandler)
-	mux.Handle("POST /login/confirm", handleLoginConfirm())
-	mux.Handle("GET /logout", handleLogout())
+	mux.Handle("POST /login/confirm", handleLoginConfirm(store, cache))
+	mux.Handle("GET /logout", handleLogout(store, cache))
 
-	mux.Handle("GET /games", chainMiddlewares(handleGames(steamAPIKey, db, cache), throttleMid, steamIDMid))
+	// a page is the shell plus its stream, only the stream is counted since it's the one
+	// that calls the steam API
+	mux.Handle("GET /games", chainMiddlewares(handleGames(steamAPIKey, store, cache), steamIDMid))
+	mux.Handle("GET /games/stream", chainMiddlewares(handleGamesStream(steamAPIKey, store, cache, crawler), throttleMid, steamIDMid))
 
 	mux.Handle("GET /server-error", handleServerErrorMyFault())
 	mux.Handle("GET /server-error/valve-fault", handleServerErrorValveFault())
@@ -365,21 +481,96 @@ func main() {
 		dbToken = ""
 	}
 
-	db, err := NewDatabase(dbURL, dbToken)
-	if err != nil {
-		slog.Error("database", "err", err)
-		os.Exit(1)
+	// Optional, to use an embedded replica of a remote database
+	dbReplicaPath := os.Getenv("DB_REPLICA_PATH")
+	dbSyncInterval := time.Minute
+
+	if syncIntervalStr, ok := os.LookupEnv("DB_SYNC_INTERVAL"); ok {
+		var err error
+		dbSyncInterval, err = time.ParseDuration(syncIntervalStr)
+		if err != nil {
+			slog.Error("invalid $DB_SYNC_INTERVAL", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	if buildflags.Dev {
+		dbReplicaPath = ""
+	}
+
+	var store Store
+
+	if dbURL == "memory:" {
+		if len(os.Args) > 1 && os.Args[1] == "migrate" {
+			slog.Error("migrate: the in-memory store has no schema")
+			os.Exit(1)
+		}
+		store = NewMemoryStore()
+	} else {
+		sqlStore, err := NewLibsqlStore(dbURL, dbToken, dbReplicaPath, dbSyncInterval)
+		if err != nil {
+			slog.Error("database", "err", err)
+			os.Exit(1)
+		}
+		store = sqlStore
+
+		if len(os.Args) > 1 && os.Args[1] == "migrate" {
+			err := runMigrateCommand(sqlStore.db, os.Args[2:])
+			_ = sqlStore.Close()
+			if err != nil {
+				slog.Error("migrate", "err", err)
+				os.Exit(1)
+			}
+			return
+		}
+
+		applied, err := migrateUp(sqlStore.db)
+		if err != nil {
+			slog.Error("apply migrations", "err", err)
+			os.Exit(1)
+		}
+		for _, migration := range applied {
+			slog.Info("applied migration", "version", migration.Version, "name", migration.Name)
+		}
 	}
 	defer func() {
-		_ = db.Close()
+		_ = store.Close()
 	}()
 
 	port := getEnvRequired("PORT")
 	steamAPIKey := getEnvRequired("STEAM_API_KEY")
 
+	if chunkSizeStr, ok := os.LookupEnv("PRICE_CHUNK_SIZE"); ok {
+		chunkSize, err := strconv.Atoi(chunkSizeStr)
+		if err != nil || chunkSize <= 0 {
+			slog.Error("invalid $PRICE_CHUNK_SIZE", "value", chunkSizeStr, "err", err)
+			os.Exit(1)
+		}
+		priceChunkSize = chunkSize
+	}
+
 	cache := newCacheGroup()
 
-	mux, err := getRoutes(steamAPIKey, db, cache)
+	categoryNames, err := store.CategoryNames()
+	if err != nil {
+		slog.Error("load category names", "err", err)
+		os.Exit(1)
+	}
+	for id, name := range categoryNames {
+		cache.categoryNames.Set(id, name)
+	}
+
+	indexedCount, err := loadCategoryGroupIndex(store, cache)
+	if err != nil {
+		slog.Error("load category group index", "err", err)
+		os.Exit(1)
+	}
+	slog.Debug("loaded category group index", "count", indexedCount)
+
+	crawler := NewCategoryCrawler(store, cache)
+	crawler.Start(10)
+
+	mux, err := getRoutes(steamAPIKey, store, cache, crawler)
 	if err != nil {
 		slog.Error("routes", "err", err)
 		os.Exit(1)
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations
+var embedMigrationsFs embed.FS
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migration files are named "<version>_<name>.up.sql" and "<version>_<name>.down.sql",
+// and are applied in ascending version order.
+func loadMigrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(embedMigrationsFs, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("read migrations dir: %v", err)
+	}
+
+	migrationsPerVersion := make(map[int]*Migration, len(entries))
+
+	for _, entry := range entries {
+		fileName := entry.Name()
+
+		var direction string
+		switch {
+		case strings.HasSuffix(fileName, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(fileName, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("invalid migration file name: %q", fileName)
+		}
+
+		versionStr, name, ok := strings.Cut(strings.TrimSuffix(fileName, "."+direction+".sql"), "_")
+		if !ok {
+			return nil, fmt.Errorf("invalid migration file name: %q", fileName)
+		}
+		version, err := strconv.Atoi(versionStr)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration version: %q", fileName)
+		}
+
+		content, err := fs.ReadFile(embedMigrationsFs, "migrations/"+fileName)
+		if err != nil {
+			return nil, fmt.Errorf("read migration %q: %v", fileName, err)
+		}
+
+		migration, ok := migrationsPerVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: name}
+			migrationsPerVersion[version] = migration
+		}
+		if migration.Name != name {
+			return nil, fmt.Errorf("migration version %d has different names: %q and %q", version, migration.Name, name)
+		}
+
+		if direction == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(migrationsPerVersion))
+	for _, migration := range migrationsPerVersion {
+		if len(migration.Up) == 0 || len(migration.Down) == 0 {
+			return nil, fmt.Errorf("migration %d_%s must have both up and down files", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	slices.SortFunc(migrations, func(a, b Migration) int {
+		return a.Version - b.Version
+	})
+
+	return migrations, nil
+}
+
+func ensureSchemaVersionTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_version (
+		version INT PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at INT NOT NULL
+	)`)
+	return err
+}
+
+func queryAppliedMigrations(db *sql.DB) (map[int]time.Time, error) {
+	rows, err := db.Query("SELECT version, applied_at FROM schema_version")
+	if err != nil {
+		return nil, fmt.Errorf("query: %v", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	applied := make(map[int]time.Time)
+
+	for rows.Next() {
+		var version int
+		var appliedAt int64
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("scan: %v", err)
+		}
+		applied[version] = time.Unix(appliedAt, 0)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %v", err)
+	}
+
+	return applied, nil
+}
+
+// splitSQLStatements splits a migration file on semicolons at the end of a line,
+// the driver executes one statement at a time.
+func splitSQLStatements(script string) []string {
+	var statements []string
+
+	for _, stmt := range strings.SplitAfter(script, ";\n") {
+		stmt = strings.TrimSpace(stmt)
+		stmt = strings.TrimSuffix(stmt, ";")
+		if len(stmt) == 0 {
+			continue
+		}
+		statements = append(statements, stmt)
+	}
+
+	return statements
+}
+
+func runMigration(db *sql.DB, migration Migration, up bool) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %v", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	script := migration.Down
+	if up {
+		script = migration.Up
+	}
+
+	for _, stmt := range splitSQLStatements(script) {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("exec %q: %v", stmt, err)
+		}
+	}
+
+	if up {
+		_, err = tx.Exec("INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)", migration.Version, migration.Name, time.Now().Unix())
+	} else {
+		_, err = tx.Exec("DELETE FROM schema_version WHERE version = ?", migration.Version)
+	}
+	if err != nil {
+		return fmt.Errorf("update schema version: %v", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("commit transaction: %v", err)
+	}
+
+	return nil
+}
+
+func getMigrationsStatus(db *sql.DB) ([]MigrationStatus, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, fmt.Errorf("load migrations: %v", err)
+	}
+
+	err = ensureSchemaVersionTable(db)
+	if err != nil {
+		return nil, fmt.Errorf("create schema_version table: %v", err)
+	}
+
+	applied, err := queryAppliedMigrations(db)
+	if err != nil {
+		return nil, fmt.Errorf("query applied migrations: %v", err)
+	}
+
+	status := make([]MigrationStatus, len(migrations))
+	for i, migration := range migrations {
+		appliedAt, ok := applied[migration.Version]
+		status[i] = MigrationStatus{
+			Migration: migration,
+			Applied:   ok,
+			AppliedAt: appliedAt,
+		}
+	}
+
+	return status, nil
+}
+
+// migrateUp applies every pending migration, each one in its own transaction.
+func migrateUp(db *sql.DB) (applied []Migration, err error) {
+	status, err := getMigrationsStatus(db)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range status {
+		if s.Applied {
+			continue
+		}
+		err := runMigration(db, s.Migration, true)
+		if err != nil {
+			return applied, fmt.Errorf("migration %d_%s up: %v", s.Version, s.Name, err)
+		}
+		applied = append(applied, s.Migration)
+	}
+
+	return applied, nil
+}
+
+// migrateDown reverts the last applied migration.
+func migrateDown(db *sql.DB) (reverted *Migration, err error) {
+	status, err := getMigrationsStatus(db)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(status) - 1; i >= 0; i-- {
+		if !status[i].Applied {
+			continue
+		}
+		migration := status[i].Migration
+
+		err := runMigration(db, migration, false)
+		if err != nil {
+			return nil, fmt.Errorf("migration %d_%s down: %v", migration.Version, migration.Name, err)
+		}
+		return &migration, nil
+	}
+
+	return nil, nil
+}
+
+func runMigrateCommand(db *sql.DB, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: migrate <up|down|status>")
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrateUp(db)
+		for _, migration := range applied {
+			fmt.Printf("applied %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("nothing to apply")
+		}
+
+	case "down":
+		reverted, err := migrateDown(db)
+		if err != nil {
+			return err
+		}
+		if reverted == nil {
+			fmt.Println("nothing to revert")
+			return nil
+		}
+		fmt.Printf("reverted %04d_%s\n", reverted.Version, reverted.Name)
+
+	case "status":
+		status, err := getMigrationsStatus(db)
+		if err != nil {
+			return err
+		}
+		for _, s := range status {
+			if s.Applied {
+				fmt.Printf("%04d_%s\tapplied at %s\n", s.Version, s.Name, s.AppliedAt.Format(time.RFC3339))
+			} else {
+				fmt.Printf("%04d_%s\tpending\n", s.Version, s.Name)
+			}
+		}
+
+	default:
+		return fmt.Errorf("unknown migrate command: %q", args[0])
+	}
+
+	return nil
+}
@@ -1,4 +1,4 @@
 CREATE TABLE IF NOT EXISTS game_categories (
     appid INT PRIMARY KEY,
     categories BLOB
-)
+);
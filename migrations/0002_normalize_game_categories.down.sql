@@ -0,0 +1,11 @@
+-- Categories are a cache of the Steam store API, so the old blobs are not rebuilt,
+-- they are fetched again on demand.
+CREATE TABLE IF NOT EXISTS game_categories (
+    appid INT PRIMARY KEY,
+    categories BLOB
+);
+
+DROP INDEX IF EXISTS game_category_category_id;
+DROP TABLE IF EXISTS game_category;
+DROP TABLE IF EXISTS games;
+DROP TABLE IF EXISTS categories;
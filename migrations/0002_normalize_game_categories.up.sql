@@ -0,0 +1,63 @@
+CREATE TABLE IF NOT EXISTS categories (
+    id INT PRIMARY KEY,
+    name TEXT NOT NULL
+);
+
+INSERT OR IGNORE INTO categories (id, name) VALUES
+    (1, 'Multi-player'),
+    (2, 'Single-player'),
+    (9, 'Co-op'),
+    (20, 'MMO'),
+    (22, 'Steam Achievements'),
+    (23, 'Steam Cloud'),
+    (24, 'Shared/Split Screen'),
+    (27, 'Cross-Platform Multiplayer'),
+    (28, 'Full controller support'),
+    (29, 'Steam Trading Cards'),
+    (30, 'Steam Workshop'),
+    (36, 'Online PvP'),
+    (37, 'Shared/Split Screen PvP'),
+    (38, 'Online Co-op'),
+    (39, 'Shared/Split Screen Co-op'),
+    (44, 'Remote Play Together'),
+    (47, 'LAN PvP'),
+    (48, 'LAN Co-op'),
+    (49, 'PvP');
+
+-- Games whose categories were already fetched, even if they have none
+CREATE TABLE IF NOT EXISTS games (
+    appid INT PRIMARY KEY,
+    categories_fetched_at INT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS game_category (
+    appid INT NOT NULL,
+    category_id INT NOT NULL,
+    PRIMARY KEY (appid, category_id)
+);
+
+CREATE INDEX IF NOT EXISTS game_category_category_id ON game_category (category_id);
+
+INSERT OR IGNORE INTO games (appid, categories_fetched_at)
+SELECT appid, unixepoch() FROM game_categories;
+
+-- The old blob is a little-endian uint16 count followed by little-endian uint16 category ids.
+-- Each id is decoded from its hex representation, "LLHH".
+WITH RECURSIVE item(appid, categories, idx) AS (
+    SELECT appid, categories, 0 FROM game_categories WHERE length(categories) >= 4
+    UNION ALL
+    SELECT appid, categories, idx + 1 FROM item WHERE 4 + 2 * (idx + 1) <= length(categories)
+),
+encoded(appid, id) AS (
+    SELECT appid, hex(substr(categories, 3 + 2 * idx, 2)) FROM item
+)
+INSERT OR IGNORE INTO game_category (appid, category_id)
+SELECT
+    appid,
+    (instr('0123456789ABCDEF', substr(id, 1, 1)) - 1) * 16
+    + (instr('0123456789ABCDEF', substr(id, 2, 1)) - 1)
+    + (instr('0123456789ABCDEF', substr(id, 3, 1)) - 1) * 4096
+    + (instr('0123456789ABCDEF', substr(id, 4, 1)) - 1) * 256
+FROM encoded;
+
+DROP TABLE game_categories;
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS preferences;
+DROP TABLE IF EXISTS library_games;
+DROP TABLE IF EXISTS libraries;
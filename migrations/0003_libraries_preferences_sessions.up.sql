@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS libraries (
+    steamid TEXT PRIMARY KEY,
+    fetched_at INT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS library_games (
+    steamid TEXT NOT NULL,
+    appid INT NOT NULL,
+    name TEXT NOT NULL,
+    playtime_2weeks INT NOT NULL,
+    playtime_forever INT NOT NULL,
+    free INT NOT NULL,
+    PRIMARY KEY (steamid, appid)
+);
+
+CREATE TABLE IF NOT EXISTS preferences (
+    steamid TEXT NOT NULL,
+    key TEXT NOT NULL,
+    value TEXT NOT NULL,
+    PRIMARY KEY (steamid, key)
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+    token TEXT PRIMARY KEY,
+    steamid TEXT NOT NULL,
+    expires_at INT NOT NULL
+);
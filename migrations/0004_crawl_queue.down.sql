@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS crawl_queue_next_attempt_at;
+DROP TABLE IF EXISTS crawl_queue;
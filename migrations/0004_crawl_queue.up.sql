@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS crawl_queue (
+    appid INT PRIMARY KEY,
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at INT NOT NULL,
+    last_error TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS crawl_queue_next_attempt_at ON crawl_queue (next_attempt_at);
@@ -0,0 +1,5 @@
+ALTER TABLE library_games ADD COLUMN free INT NOT NULL DEFAULT 0;
+
+UPDATE library_games SET free = price_status = 1;
+
+ALTER TABLE library_games DROP COLUMN price_status;
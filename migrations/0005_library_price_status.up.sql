@@ -0,0 +1,7 @@
+-- 0 unknown, 1 free, 2 paid, 3 unavailable
+ALTER TABLE library_games ADD COLUMN price_status INT NOT NULL DEFAULT 0;
+
+ALTER TABLE library_games DROP COLUMN free;
+
+-- "free" was also true when the price was unknown, so the libraries are fetched again
+UPDATE libraries SET fetched_at = 0;
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Preference keys
+const (
+	// comma separated names from categoryGroupFilters
+	PreferenceCategoryFilter = "category_filter"
+	// country code of the prices, the one of the steam profile if empty
+	PreferenceCountryCode = "country_code"
+)
+
+type Library struct {
+	Games     map[int]SteamGame
+	FetchedAt time.Time
+}
+
+// CrawlEntry is an app in the crawl queue, whose categories were not fetched yet
+type CrawlEntry struct {
+	AppID         int
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+}
+
+type Session struct {
+	Token     string
+	SteamID   string
+	ExpiresAt time.Time
+}
+
+// Store is the persistent storage used by the handlers, the steam API responses it holds
+// are only a cache.
+type Store interface {
+	// GameCategories returns the categories of the games that were already fetched,
+	// games with no categories are included with a nil slice.
+	GameCategories(appIDs []int) (map[int][]int, error)
+	SaveGameCategories(categoriesPerGame map[int][]int) error
+	// GameCategoriesIn returns every game whose categories were fetched, with only its
+	// categories among categoryIDs
+	GameCategoriesIn(categoryIDs []int) (map[int][]int, error)
+
+	CategoryNames() (map[int]string, error)
+	SaveCategoryNames(names map[int]string) error
+
+	Library(steamID string) (library Library, ok bool, err error)
+	SaveLibrary(steamID string, library Library) error
+	// SavePriceStatuses updates the price status of the games in every library
+	SavePriceStatuses(statuses map[int]PriceStatus) error
+
+	Preference(steamID, key string) (value string, ok bool, err error)
+	SavePreference(steamID, key, value string) error
+
+	// QueueCrawls adds the apps to the crawl queue, the ones already queued are kept as they are
+	QueueCrawls(appIDs []int) error
+	Crawl(appID int) (entry CrawlEntry, ok bool, err error)
+	// DueCrawls returns up to limit queued apps whose next attempt is due, the oldest first
+	DueCrawls(now time.Time, limit int) ([]CrawlEntry, error)
+	// SaveCrawlAttempt adds or replaces an app in the crawl queue
+	SaveCrawlAttempt(entry CrawlEntry) error
+	DeleteCrawls(appIDs []int) error
+
+	Session(token string) (session Session, ok bool, err error)
+	SaveSession(session Session) error
+	DeleteSession(token string) error
+
+	Close() error
+}
+
+type memoryStore struct {
+	gameCategories map[int][]int
+	categoryNames  map[int]string
+	libraries      map[string]Library
+	preferences    map[[2]string]string
+	sessions       map[string]Session
+	crawlQueue     map[int]CrawlEntry
+	mu             sync.RWMutex
+}
+
+func NewMemoryStore() *memoryStore {
+	return &memoryStore{
+		gameCategories: make(map[int][]int),
+		categoryNames:  make(map[int]string),
+		libraries:      make(map[string]Library),
+		preferences:    make(map[[2]string]string),
+		sessions:       make(map[string]Session),
+		crawlQueue:     make(map[int]CrawlEntry),
+	}
+}
+
+func (s *memoryStore) GameCategories(appIDs []int) (map[int][]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	categoriesPerGame := make(map[int][]int, len(appIDs))
+	for _, appID := range appIDs {
+		if categories, ok := s.gameCategories[appID]; ok {
+			categoriesPerGame[appID] = slices.Clone(categories)
+		}
+	}
+	return categoriesPerGame, nil
+}
+
+func (s *memoryStore) SaveGameCategories(categoriesPerGame map[int][]int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for appID, categories := range categoriesPerGame {
+		s.gameCategories[appID] = slices.Clone(categories)
+	}
+	return nil
+}
+
+func (s *memoryStore) GameCategoriesIn(categoryIDs []int) (map[int][]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	categoriesPerGame := make(map[int][]int, len(s.gameCategories))
+	for appID, categories := range s.gameCategories {
+		categoriesPerGame[appID] = slices.DeleteFunc(slices.Clone(categories), func(category int) bool {
+			return !slices.Contains(categoryIDs, category)
+		})
+	}
+	return categoriesPerGame, nil
+}
+
+func (s *memoryStore) CategoryNames() (map[int]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return maps.Clone(s.categoryNames), nil
+}
+
+func (s *memoryStore) SaveCategoryNames(names map[int]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	maps.Copy(s.categoryNames, names)
+	return nil
+}
+
+func (s *memoryStore) Library(steamID string) (Library, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	library, ok := s.libraries[steamID]
+	if !ok {
+		return Library{}, false, nil
+	}
+	library.Games = maps.Clone(library.Games)
+	return library, true, nil
+}
+
+func (s *memoryStore) SaveLibrary(steamID string, library Library) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	library.Games = maps.Clone(library.Games)
+	s.libraries[steamID] = library
+	return nil
+}
+
+func (s *memoryStore) SavePriceStatuses(statuses map[int]PriceStatus) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, library := range s.libraries {
+		for appID, status := range statuses {
+			if game, ok := library.Games[appID]; ok {
+				game.PriceStatus = status
+				library.Games[appID] = game
+			}
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) Preference(steamID, key string) (string, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	value, ok := s.preferences[[2]string{steamID, key}]
+	return value, ok, nil
+}
+
+func (s *memoryStore) SavePreference(steamID, key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.preferences[[2]string{steamID, key}] = value
+	return nil
+}
+
+func (s *memoryStore) QueueCrawls(appIDs []int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, appID := range appIDs {
+		if _, ok := s.crawlQueue[appID]; !ok {
+			s.crawlQueue[appID] = CrawlEntry{AppID: appID, NextAttemptAt: now}
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) Crawl(appID int) (CrawlEntry, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, ok := s.crawlQueue[appID]
+	return entry, ok, nil
+}
+
+func (s *memoryStore) DueCrawls(now time.Time, limit int) ([]CrawlEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var entries []CrawlEntry
+	for _, entry := range s.crawlQueue {
+		if !entry.NextAttemptAt.After(now) {
+			entries = append(entries, entry)
+		}
+	}
+	slices.SortFunc(entries, func(a, b CrawlEntry) int {
+		return a.NextAttemptAt.Compare(b.NextAttemptAt)
+	})
+	return entries[:min(limit, len(entries))], nil
+}
+
+func (s *memoryStore) SaveCrawlAttempt(entry CrawlEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.crawlQueue[entry.AppID] = entry
+	return nil
+}
+
+func (s *memoryStore) DeleteCrawls(appIDs []int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, appID := range appIDs {
+		delete(s.crawlQueue, appID)
+	}
+	return nil
+}
+
+func (s *memoryStore) Session(token string) (Session, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, ok := s.sessions[token]
+	if !ok || time.Now().After(session.ExpiresAt) {
+		return Session{}, false, nil
+	}
+	return session, true, nil
+}
+
+func (s *memoryStore) SaveSession(session Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions[session.Token] = session
+	return nil
+}
+
+func (s *memoryStore) DeleteSession(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, token)
+	return nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
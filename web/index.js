@@ -39,3 +39,68 @@ function favoriteFriendToggle(el) {
 
     document.cookie = cookieName + '=' + newFavorites + '; SameSite=strict'
 }
+
+/**
+ * Streams the games of a page from `data-games-stream`, each card is inserted before the
+ * element as soon as it arrives, and the element is replaced by the end of the page.
+ * @param {HTMLElement} el
+ */
+function streamGames(el) {
+    const streamURL = el.getAttribute('data-games-stream')
+    if (streamURL === null) {
+        throw new Error("missing 'data-games-stream'")
+    }
+
+    const source = new EventSource(streamURL)
+
+    source.addEventListener('notice', (event) => {
+        el.insertAdjacentHTML('beforebegin', event.data)
+    })
+
+    source.addEventListener('game', (event) => {
+        if (!el.isConnected) {
+            source.close()
+            return
+        }
+        el.insertAdjacentHTML('beforebegin', event.data)
+    })
+
+    source.addEventListener('done', (event) => {
+        source.close()
+        if (el.isConnected) {
+            // @ts-ignore
+            htmx.swap(el, event.data, { swapStyle: 'outerHTML' })
+        }
+    })
+
+    source.addEventListener('redirect', (event) => {
+        source.close()
+        window.location.href = event.data
+    })
+
+    source.addEventListener('error', () => {
+        // the server refused it, otherwise the connection was lost
+        const refused = source.readyState === EventSource.CLOSED
+        // reconnecting would send the page again
+        source.close()
+        if (refused) {
+            window.location.href = '/server-error'
+        } else {
+            el.remove()
+        }
+    })
+}
+
+// @ts-ignore
+htmx.onLoad((/** @type {Node} */ el) => {
+    if (!(el instanceof HTMLElement)) {
+        return
+    }
+    if (el.matches('[data-games-stream]')) {
+        streamGames(el)
+    }
+    for (const streamEl of el.querySelectorAll('[data-games-stream]')) {
+        // @ts-ignore
+        streamGames(streamEl)
+    }
+})